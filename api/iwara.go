@@ -8,12 +8,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"iwaradl/config"
+	"iwaradl/metrics"
 	"iwaradl/util"
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	http "github.com/bogdanfinn/fhttp"
@@ -22,11 +21,7 @@ import (
 )
 
 var (
-	Token         string
-	Client        tlsClient.HttpClient
-	runtimeCookie string
-	runtimeMu     sync.Mutex
-	commHeaders   = http.Header{
+	commHeaders = http.Header{
 		"accept":             {"application/json, text/plain, */*"},
 		"accept-encoding":    {"gzip, deflate, br, zstd"},
 		"accept-language":    {"zh-CN,zh;q=0.9,en-US;q=0.8,en;q=0.7,ja;q=0.6"},
@@ -73,8 +68,7 @@ func defaultClientProfile() profiles.ClientProfile {
 	return profiles.Chrome_146_PSK
 }
 
-func initClient(proxyURL string) error {
-	var err error
+func newClient(proxyURL string) (tlsClient.HttpClient, error) {
 	options := []tlsClient.HttpClientOption{
 		tlsClient.WithTimeoutSeconds(60),
 		tlsClient.WithClientProfile(defaultClientProfile()),
@@ -86,44 +80,14 @@ func initClient(proxyURL string) error {
 		options = append(options, tlsClient.WithProxyUrl(proxyURL))
 		util.DebugLog("Using proxy: %s", proxyURL)
 	}
-	Client, err = tlsClient.NewHttpClient(tlsClient.NewNoopLogger(), options...)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func init() {
-	if err := initClient(config.Cfg.ProxyUrl); err != nil {
-		panic(err)
-	}
-}
-
-func ExecuteWithRuntimeOptions(proxyURL string, cookie string, fn func() int) int {
-	runtimeMu.Lock()
-	defer runtimeMu.Unlock()
-
-	prevCookie := runtimeCookie
-	runtimeCookie = cookie
-
-	if err := initClient(proxyURL); err != nil {
-		runtimeCookie = prevCookie
-		_ = initClient(config.Cfg.ProxyUrl)
-		return fn()
-	}
-
-	result := fn()
-
-	runtimeCookie = prevCookie
-	_ = initClient(config.Cfg.ProxyUrl)
-	return result
+	return tlsClient.NewHttpClient(tlsClient.NewNoopLogger(), options...)
 }
 
 // GetVideoInfo Get the video info JSON from the API server
-func GetVideoInfo(id string, host string) (info VideoInfo, err error) {
+func (s *Session) GetVideoInfo(id string, host string) (info VideoInfo, err error) {
 	util.DebugLog("Starting to get video info, ID: %s", id)
 	u := "https://api.iwara.tv/video/" + id
-	body, err := Fetch(u, "", host)
+	body, err := s.Fetch(u, "", host)
 	if err != nil {
 		util.DebugLog("Failed to get video info: %v", err)
 		return
@@ -137,14 +101,26 @@ func GetVideoInfo(id string, host string) (info VideoInfo, err error) {
 	return
 }
 
-// Fetch the url and return the response body
-func Fetch(u string, xversion string, host string) (data []byte, err error) {
+// Fetch the url and return the response body. A 401 response drops the
+// cached access token and retries once with a fresh one.
+func (s *Session) Fetch(u string, xversion string, host string) (data []byte, err error) {
+	data, authorized, err := s.fetchOnce(u, xversion, host)
+	var httpErr *HTTPError
+	if authorized && errors.As(err, &httpErr) && httpErr.StatusCode == 401 {
+		util.Log.Warn("Access token rejected, retrying with a new one", "host", host)
+		s.tokens.Invalidate()
+		data, _, err = s.fetchOnce(u, xversion, host)
+	}
+	return data, err
+}
+
+func (s *Session) fetchOnce(u string, xversion string, host string) (data []byte, authorized bool, err error) {
 	util.DebugLog("Starting to request URL: %s", u)
 
 	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		util.DebugLog("Failed to create request: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
 	req.Header = make(http.Header)
@@ -152,28 +128,18 @@ func Fetch(u string, xversion string, host string) (data []byte, err error) {
 		req.Header[k] = append([]string(nil), v...)
 	}
 
-	if (config.Cfg.Authorization != "" || config.Cfg.Email != "") && Token == "" {
-		util.DebugLog("Getting access token")
-		Token, err = GetAccessToken(config.Cfg.Authorization, host)
-		if err != nil {
-			// Try to refresh the authorization token
-			if config.Cfg.Email != "" && config.Cfg.Password != "" {
-				newAuth, refreshErr := RefreshAuthToken(host)
-				if refreshErr == nil {
-					Token, err = GetAccessToken(newAuth, host)
-				} else {
-					util.DebugLog("Failed to refresh authorization token: %v", refreshErr)
-					//return nil, refreshErr
-				}
-			}
-		}
+	// without a token the request still goes out anonymously
+	token, tokenErr := s.tokens.AccessToken(s.client, host)
+	if tokenErr != nil {
+		util.Log.Warn("Requesting without access token", "host", host, "err", tokenErr)
 	}
-	if Token != "" {
-		req.Header.Set("Authorization", "Bearer "+Token)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		authorized = true
 		util.DebugLog("Setting Authorization header")
 	}
-	if runtimeCookie != "" {
-		req.Header.Set("Cookie", runtimeCookie)
+	if s.cookie != "" {
+		req.Header.Set("Cookie", s.cookie)
 	}
 
 	if xversion != "" {
@@ -181,10 +147,11 @@ func Fetch(u string, xversion string, host string) (data []byte, err error) {
 		util.DebugLog("Setting X-Version header: %s", xversion)
 	}
 
-	resp, err := Client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		util.DebugLog("Failed to send request: %v", err)
-		return nil, err
+		metrics.APIRequests.Inc("error")
+		return nil, authorized, err
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -193,30 +160,54 @@ func Fetch(u string, xversion string, host string) (data []byte, err error) {
 			return
 		}
 	}(resp.Body)
+	metrics.APIRequests.Inc(strconv.Itoa(resp.StatusCode))
+	if headerValueIgnoreCase(resp.Header, "cf-mitigated") != "" {
+		metrics.CloudflareMitigations.Inc("")
+	}
 	if resp.StatusCode != 200 {
-		util.DebugLog("Invalid HTTP status code: %d", resp.StatusCode)
+		util.Log.Warn("Iwara API request failed", "url", u, "host", host, "status", resp.StatusCode)
 		body, readErr := io.ReadAll(resp.Body)
 		if readErr != nil {
 			util.DebugLog("Failed to read error response body: %v", readErr)
 		}
 		err = formatHTTPError(resp, body)
 		util.DebugLog("HTTP error details: %v", err)
-		return nil, err
+		return nil, authorized, err
 	}
 	data, err = io.ReadAll(resp.Body)
 	if err != nil {
 		util.DebugLog("Failed to read response body: %v", err)
-		return nil, err
+		return nil, authorized, err
 	}
 	util.DebugLog("Successfully got response, data length: %d", len(data))
 	return
 }
 
+func observeTokenRefresh(err error) {
+	if err != nil {
+		metrics.TokenRefreshes.Inc("error")
+		return
+	}
+	metrics.TokenRefreshes.Inc("ok")
+}
+
+// HTTPError is returned by Fetch for non-200 responses.
+type HTTPError struct {
+	StatusCode  int
+	CFMitigated string
+	msg         string
+}
+
+func (e *HTTPError) Error() string {
+	return e.msg
+}
+
 func formatHTTPError(resp *http.Response, body []byte) error {
 	parts := []string{"http status code: " + strconv.Itoa(resp.StatusCode)}
 
-	if v := headerValueIgnoreCase(resp.Header, "cf-mitigated"); v != "" {
-		parts = append(parts, "cf-mitigated="+v)
+	cfMitigated := headerValueIgnoreCase(resp.Header, "cf-mitigated")
+	if cfMitigated != "" {
+		parts = append(parts, "cf-mitigated="+cfMitigated)
 	}
 	if v := headerValueIgnoreCase(resp.Header, "server"); v != "" {
 		parts = append(parts, "server="+v)
@@ -228,7 +219,7 @@ func formatHTTPError(resp *http.Response, body []byte) error {
 		parts = append(parts, "body="+snippet)
 	}
 
-	return errors.New(strings.Join(parts, "; "))
+	return &HTTPError{StatusCode: resp.StatusCode, CFMitigated: cfMitigated, msg: strings.Join(parts, "; ")}
 }
 
 func headerValueIgnoreCase(h http.Header, key string) string {
@@ -262,7 +253,14 @@ func SHA1(s string) string {
 }
 
 // GetVideoUrl Get the mp4 source url of the video info
-func GetVideoUrl(vi VideoInfo, host string) (string, string) {
+func (s *Session) GetVideoUrl(vi VideoInfo, host string) (string, string) {
+	return s.GetVideoUrlQuality(vi, host, "")
+}
+
+// GetVideoUrlQuality Get the mp4 url of the video info in the given quality,
+// e.g. "Source" or "540". A numeric quality falls back to the best lower
+// resolution, then the lowest one; an empty quality prefers Source.
+func (s *Session) GetVideoUrlQuality(vi VideoInfo, host string, quality string) (string, string) {
 	util.DebugLog("Starting to get video download URL, ID: %s", vi.Id)
 	u := vi.FileUrl
 	parsed, err := url.Parse(u)
@@ -273,7 +271,7 @@ func GetVideoUrl(vi VideoInfo, host string) (string, string) {
 	expires := parsed.Query().Get("expires")
 	xv := vi.File.Id + "_" + expires + "_mSvL05GfEmeEmsEYfGCnVpEjYgTJraJN"
 	xversion := SHA1(xv)
-	body, err := Fetch(u, xversion, host)
+	body, err := s.Fetch(u, xversion, host)
 	if err != nil {
 		util.DebugLog("Failed to get video URL: %v", err)
 		return "", ""
@@ -284,24 +282,60 @@ func GetVideoUrl(vi VideoInfo, host string) (string, string) {
 		util.DebugLog("Failed to parse video URL: %v", err)
 		return "", ""
 	}
+	v, ok := pickResolution(rList, quality)
+	if !ok {
+		util.DebugLog("Source video URL not found")
+		return "", ""
+	}
+	util.DebugLog("Successfully got video download URL, quality: %s", v.Name)
+	return `https:` + v.Src.Download, v.Name
+}
+
+func pickResolution(rList []ResolutionInfo, quality string) (ResolutionInfo, bool) {
+	if quality != "" {
+		for _, v := range rList {
+			if strings.EqualFold(v.Name, quality) {
+				return v, true
+			}
+		}
+		if want, err := strconv.Atoi(quality); err == nil {
+			best, lowest := -1, -1
+			var bestN, lowestN int
+			for i, v := range rList {
+				n, err := strconv.Atoi(v.Name)
+				if err != nil {
+					continue
+				}
+				if n <= want && (best < 0 || n > bestN) {
+					best, bestN = i, n
+				}
+				if lowest < 0 || n < lowestN {
+					lowest, lowestN = i, n
+				}
+			}
+			if best >= 0 {
+				return rList[best], true
+			}
+			if lowest >= 0 {
+				return rList[lowest], true
+			}
+		}
+	}
 	for _, v := range rList {
 		if v.Name == "Source" {
-			util.DebugLog("Successfully got video download URL")
-			return `https:` + v.Src.Download, v.Name
+			return v, true
 		}
 	}
 	if len(rList) > 0 {
-		v := rList[0]
-		return `https:` + v.Src.Download, v.Name
+		return rList[0], true
 	}
-	util.DebugLog("Source video URL not found")
-	return "", ""
+	return ResolutionInfo{}, false
 }
 
 // GetUserProfile Get user profile by username
-func GetUserProfile(username string, host string) (profile UserProfile, err error) {
+func (s *Session) GetUserProfile(username string, host string) (profile UserProfile, err error) {
 	u := "https://api.iwara.tv/profile/" + username
-	body, err := Fetch(u, "", host)
+	body, err := s.Fetch(u, "", host)
 	if err != nil {
 		util.DebugLog("Failed to get user profile: %v", err)
 		return
@@ -310,10 +344,21 @@ func GetUserProfile(username string, host string) (profile UserProfile, err erro
 	return
 }
 
+// GetCurrentUser Get the profile of the logged-in user
+func (s *Session) GetCurrentUser(host string) (profile UserProfile, err error) {
+	body, err := s.Fetch("https://api.iwara.tv/user", "", host)
+	if err != nil {
+		util.DebugLog("Failed to get current user: %v", err)
+		return
+	}
+	err = json.Unmarshal(body, &profile)
+	return
+}
+
 // GetVideoListByUser Get the video list of the user
-func GetVideoListByUser(username string, host string) []VideoInfo {
+func (s *Session) GetVideoListByUser(username string, host string) []VideoInfo {
 	util.DebugLog("Starting to get user video list, username: %s", username)
-	profile, err := GetUserProfile(username, host)
+	profile, err := s.GetUserProfile(username, host)
 	if err != nil {
 		util.DebugLog("Failed to get user info: %v", err)
 		return nil
@@ -325,7 +370,7 @@ func GetVideoListByUser(username string, host string) []VideoInfo {
 
 	for i := 0; ; i++ {
 		u := "https://api.iwara.tv/videos?rating=all&sort=date&page=" + strconv.Itoa(i) + "&user=" + uid
-		body, err := Fetch(u, "", host)
+		body, err := s.Fetch(u, "", host)
 		if err != nil {
 			util.DebugLog("Failed to get page %d: %v", i+1, err)
 			if retry > 0 {
@@ -362,9 +407,9 @@ func GetVideoListByUser(username string, host string) []VideoInfo {
 // sort: "date", "trending", "popularity", "views", "likes"
 // page: 0, 1, 2, 3, ...
 // rating: "all", "general", "ecchi"
-func GetVideoList(sort string, page int, rating string, host string) (list VideoList, err error) {
+func (s *Session) GetVideoList(sort string, page int, rating string, host string) (list VideoList, err error) {
 	u := "https://api.iwara.tv/videos?sort=" + sort + "&page=" + strconv.Itoa(page) + "&rating=" + rating
-	data, err := Fetch(u, "", host)
+	data, err := s.Fetch(u, "", host)
 	if err != nil {
 		return
 	}
@@ -403,8 +448,8 @@ func GetDetailInfo(vi VideoInfo) (DetailInfo, error) {
 	return di, nil
 }
 
-// GetAccessToken Get access token using authorization token
-func GetAccessToken(auth string, host string) (string, error) {
+// getAccessToken Get access token using authorization token
+func getAccessToken(client tlsClient.HttpClient, auth string, host string) (string, error) {
 	u := "https://api.iwara.tv/user/token"
 
 	req, err := http.NewRequest("POST", u, nil)
@@ -420,14 +465,14 @@ func GetAccessToken(auth string, host string) (string, error) {
 
 	req.Header.Set("Authorization", "Bearer "+auth)
 
-	resp, err := Client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			println(err.Error())
+			util.DebugLog("Failed to close response body: %v", err)
 		}
 	}(resp.Body)
 	if resp.StatusCode != 200 {
@@ -450,16 +495,15 @@ func GetAccessToken(auth string, host string) (string, error) {
 	return token.AccessToken, nil
 }
 
-// RefreshAuthToken Refresh Authorization Token with username and password
-func RefreshAuthToken(host string) (string, error) {
+func login(client tlsClient.HttpClient, email string, password string, host string) (string, error) {
 	u := "https://api.iwara.tv/user/login"
 
 	body := struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}{
-		Email:    config.Cfg.Email,
-		Password: config.Cfg.Password,
+		Email:    email,
+		Password: password,
 	}
 	bodyData, err := json.Marshal(body)
 	if err != nil {
@@ -477,14 +521,14 @@ func RefreshAuthToken(host string) (string, error) {
 	}
 	req.Header.Set("content-type", "application/json")
 
-	resp, err := Client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			println(err.Error())
+			util.DebugLog("Failed to close response body: %v", err)
 		}
 	}(resp.Body)
 	if resp.StatusCode != 200 {
@@ -506,10 +550,5 @@ func RefreshAuthToken(host string) (string, error) {
 		return "", err
 	}
 
-	config.Cfg.Authorization = token.AuthToken
-	if err := config.SaveConfig(&config.Cfg); err != nil {
-		util.DebugLog("Failed to save config: %v", err)
-	}
-
 	return token.AuthToken, nil
 }
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"iwaradl/config"
+	"iwaradl/util"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	tlsClient "github.com/bogdanfinn/tls-client"
+)
+
+// Session talks to the iwara API as one account. Every session has its own
+// HTTP client and cookie jar and only uses the token cache of its account,
+// so requests never carry another account's credentials.
+type Session struct {
+	client  tlsClient.HttpClient
+	tokens  *TokenManager
+	cookie  string
+	account string
+}
+
+var (
+	current   atomic.Pointer[Session] // used by the package-level functions
+	base      atomic.Pointer[Session] // selected at startup, current outside runs
+	runtimeMu sync.Mutex
+)
+
+func init() {
+	s, err := newSession(config.Cfg.ProxyUrl, "", "")
+	if err != nil {
+		panic(err)
+	}
+	current.Store(s)
+	base.Store(s)
+}
+
+// NewSession creates a session for a configured account; "" is the default
+// account.
+func NewSession(proxyURL string, cookie string, account string) (*Session, error) {
+	if _, ok := config.Cfg.LookupAccount(account); !ok {
+		return nil, errors.New("unknown account: " + account)
+	}
+	return newSession(proxyURL, cookie, account)
+}
+
+func newSession(proxyURL string, cookie string, account string) (*Session, error) {
+	client, err := newClient(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		client:  client,
+		tokens:  tokenManager(account),
+		cookie:  cookie,
+		account: account,
+	}, nil
+}
+
+// Account returns the account name of the session.
+func (s *Session) Account() string {
+	return s.account
+}
+
+// Current returns the session used by the package-level functions.
+func Current() *Session {
+	return current.Load()
+}
+
+// Default returns the session selected at startup. Unlike Current it is not
+// replaced while ExecuteWithRuntimeOptions runs.
+func Default() *Session {
+	return base.Load()
+}
+
+// UseAccount replaces the default session with a new one for account.
+func UseAccount(account string) error {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	s, err := NewSession(config.Cfg.ProxyUrl, "", account)
+	if err != nil {
+		return err
+	}
+	current.Store(s)
+	base.Store(s)
+	return nil
+}
+
+// ExecuteWithRuntimeOptions runs fn with a session for the given proxy, cookie
+// and account installed as the current one, and restores the previous session
+// afterwards. Runs are serialized.
+func ExecuteWithRuntimeOptions(proxyURL string, cookie string, account string, fn func() int) int {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+
+	s, err := newSession(proxyURL, cookie, account)
+	if err != nil {
+		util.Log.Warn("Failed to create client, using configured proxy", "proxy", proxyURL, "err", err)
+		if s, err = newSession(config.Cfg.ProxyUrl, cookie, account); err != nil {
+			// keep the account's tokens even when reusing the current client
+			util.Log.Error("Failed to create client, reusing the current one", "err", err)
+			s = &Session{client: Current().client, tokens: tokenManager(account), cookie: cookie, account: account}
+		}
+	}
+
+	prev := current.Swap(s)
+	defer current.Store(prev)
+	return fn()
+}
+
+// ValidateProxyURL checks that raw is a URL with a scheme supported by the
+// HTTP client.
+func ValidateProxyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("invalid proxy url")
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" && scheme != "socks5" {
+		return errors.New("proxy url scheme must be http, https or socks5")
+	}
+	return nil
+}
+
+// Login logs in with email and password and stores only the returned
+// authorization token for the account of the current session.
+func Login(email string, password string, host string) error {
+	s := Current()
+	auth, err := login(s.client, email, password, host)
+	if err != nil {
+		return err
+	}
+	s.tokens.SetAuthToken(auth)
+	return nil
+}
+
+// Logout removes the stored tokens of the current account.
+func Logout() error {
+	return Current().tokens.Clear()
+}
+
+// GetAccessToken Get access token using authorization token
+func GetAccessToken(auth string, host string) (string, error) {
+	return getAccessToken(Current().client, auth, host)
+}
+
+// Fetch the url with the current session.
+func Fetch(u string, xversion string, host string) ([]byte, error) {
+	return Current().Fetch(u, xversion, host)
+}
+
+// GetVideoInfo Get the video info JSON from the API server
+func GetVideoInfo(id string, host string) (VideoInfo, error) {
+	return Current().GetVideoInfo(id, host)
+}
+
+// GetVideoUrl Get the mp4 source url of the video info
+func GetVideoUrl(vi VideoInfo, host string) (string, string) {
+	return Current().GetVideoUrl(vi, host)
+}
+
+// GetVideoUrlQuality Get the mp4 url of the video info in the given quality
+func GetVideoUrlQuality(vi VideoInfo, host string, quality string) (string, string) {
+	return Current().GetVideoUrlQuality(vi, host, quality)
+}
+
+// GetUserProfile Get user profile by username
+func GetUserProfile(username string, host string) (UserProfile, error) {
+	return Current().GetUserProfile(username, host)
+}
+
+// GetCurrentUser Get the profile of the logged-in user
+func GetCurrentUser(host string) (UserProfile, error) {
+	return Current().GetCurrentUser(host)
+}
+
+// GetVideoListByUser Get the video list of the user
+func GetVideoListByUser(username string, host string) []VideoInfo {
+	return Current().GetVideoListByUser(username, host)
+}
+
+// GetVideoList Get video list
+func GetVideoList(sort string, page int, rating string, host string) (VideoList, error) {
+	return Current().GetVideoList(sort, page, rating, host)
+}
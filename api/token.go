@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"iwaradl/config"
+	"iwaradl/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tlsClient "github.com/bogdanfinn/tls-client"
+)
+
+// refreshMargin is how long before expiry an access token is renewed.
+const refreshMargin = 5 * time.Minute
+
+// Credentials is the content of the credentials file.
+type Credentials struct {
+	AuthToken   string `json:"authToken,omitempty"`
+	AccessToken string `json:"accessToken,omitempty"`
+}
+
+// TokenManager keeps the authorization and access tokens, renews the access
+// token before its JWT expiry and persists both to the credentials file.
+type TokenManager struct {
+	mu          sync.Mutex
+	account     string
+	loaded      bool
+	authToken   string
+	accessToken string
+}
+
+var (
+	managers   = make(map[string]*TokenManager)
+	managersMu sync.Mutex
+)
+
+// tokenManager returns the shared token cache of account.
+func tokenManager(account string) *TokenManager {
+	managersMu.Lock()
+	defer managersMu.Unlock()
+	m, ok := managers[account]
+	if !ok {
+		m = &TokenManager{account: account}
+		managers[account] = m
+	}
+	return m
+}
+
+// credentials returns the configured credentials of the manager's account.
+// An account removed from the config has none.
+func (m *TokenManager) credentials() config.Account {
+	acc, _ := config.Cfg.LookupAccount(m.account)
+	return acc
+}
+
+// AccessToken returns a valid access token for host, refreshing it when it
+// is missing or about to expire. It returns "" when no credentials are configured.
+func (m *TokenManager) AccessToken(client tlsClient.HttpClient, host string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	if m.authToken == "" && m.credentials().Email == "" {
+		return "", nil
+	}
+	if m.accessToken != "" && !expiresWithin(m.accessToken, refreshMargin) {
+		return m.accessToken, nil
+	}
+	return m.refresh(client, host)
+}
+
+// Invalidate drops the cached access token, e.g. after a 401 response.
+func (m *TokenManager) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accessToken = ""
+}
+
+// SetAuthToken replaces the authorization token and persists it.
+func (m *TokenManager) SetAuthToken(auth string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	m.authToken = auth
+	m.accessToken = ""
+	m.save()
+}
+
+// Clear forgets all tokens and removes the credentials file.
+func (m *TokenManager) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loaded = true
+	m.authToken = ""
+	m.accessToken = ""
+	err := os.Remove(m.credentials().CredentialsFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (m *TokenManager) refresh(client tlsClient.HttpClient, host string) (string, error) {
+	util.DebugLog("Getting access token")
+	if m.authToken != "" && !expiresWithin(m.authToken, 0) {
+		token, err := getAccessToken(client, m.authToken, host)
+		observeTokenRefresh(err)
+		if err == nil {
+			m.accessToken = token
+			m.save()
+			return token, nil
+		}
+		util.Log.Warn("Failed to get access token", "account", m.account, "host", host, "err", err)
+	}
+
+	// The authorization token is missing, expired or rejected: log in again.
+	acc := m.credentials()
+	if acc.Email == "" || acc.Password == "" {
+		return "", errors.New("authorization token is invalid and no email/password is configured")
+	}
+	auth, err := login(client, acc.Email, acc.Password, host)
+	if err != nil {
+		util.Log.Error("Failed to refresh authorization token", "account", m.account, "host", host, "err", err)
+		return "", err
+	}
+	m.authToken = auth
+	token, err := getAccessToken(client, auth, host)
+	observeTokenRefresh(err)
+	if err != nil {
+		return "", err
+	}
+	m.accessToken = token
+	m.save()
+	return token, nil
+}
+
+// load reads the credentials file once. A stored authorization token wins
+// over the configured one only if it expires later, so a token pasted into
+// config.yaml replaces an older stored one.
+func (m *TokenManager) load() {
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+	acc := m.credentials()
+	m.authToken = acc.Authorization
+
+	data, err := os.ReadFile(acc.CredentialsFile)
+	if err != nil {
+		return
+	}
+	var c Credentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		util.Log.Warn("Failed to parse credentials file", "file", acc.CredentialsFile, "err", err)
+		return
+	}
+	if c.AuthToken != "" && (m.authToken == "" || laterExpiry(c.AuthToken, m.authToken)) {
+		m.authToken = c.AuthToken
+	}
+	if c.AuthToken == m.authToken {
+		m.accessToken = c.AccessToken
+	}
+}
+
+func (m *TokenManager) save() {
+	path := m.credentials().CredentialsFile
+	data, err := json.MarshalIndent(Credentials{AuthToken: m.authToken, AccessToken: m.accessToken}, "", "  ")
+	if err != nil {
+		return
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		_ = os.MkdirAll(dir, 0700)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		util.Log.Error("Failed to save credentials", "file", path, "err", err)
+		return
+	}
+	// WriteFile keeps the mode of an existing file
+	_ = os.Chmod(path, 0600)
+}
+
+// JWTExpiry returns the exp claim of a JWT, or false if it has none.
+func JWTExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(claims.Exp), 0), true
+}
+
+// expiresWithin reports whether token expires in less than d. Tokens without
+// a readable expiry are assumed valid.
+func expiresWithin(token string, d time.Duration) bool {
+	exp, ok := JWTExpiry(token)
+	if !ok {
+		return false
+	}
+	return time.Until(exp) < d
+}
+
+func laterExpiry(a, b string) bool {
+	expA, okA := JWTExpiry(a)
+	expB, okB := JWTExpiry(b)
+	if !okA || !okB {
+		return false
+	}
+	return expA.After(expB)
+}
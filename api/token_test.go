@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func fakeJWT(payload string) string {
+	return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp, ok := JWTExpiry(fakeJWT(`{"id":"u","exp":1767225600}`))
+	if !ok || !exp.Equal(time.Unix(1767225600, 0)) {
+		t.Fatalf("JWTExpiry = %v, %v, want 1767225600", exp, ok)
+	}
+	for _, token := range []string{"", "opaque-token", fakeJWT(`{"id":"u"}`), "a.!!!.c"} {
+		if _, ok := JWTExpiry(token); ok {
+			t.Fatalf("JWTExpiry(%q) reported an expiry", token)
+		}
+	}
+}
+
+func TestExpiresWithin(t *testing.T) {
+	soon := fakeJWT(`{"exp":` + strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10) + `}`)
+	later := fakeJWT(`{"exp":` + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `}`)
+
+	if !expiresWithin(soon, refreshMargin) {
+		t.Fatal("token expiring in 1m should be refreshed")
+	}
+	if expiresWithin(later, refreshMargin) {
+		t.Fatal("token expiring in 1h should not be refreshed")
+	}
+	if expiresWithin("opaque-token", refreshMargin) {
+		t.Fatal("token without expiry should be treated as valid")
+	}
+	if !laterExpiry(later, soon) || laterExpiry(soon, later) {
+		t.Fatal("laterExpiry should compare exp claims")
+	}
+}
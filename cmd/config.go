@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"iwaradl/api"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/hook"
+	"iwaradl/server"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file and print the effective configuration",
+	Long: `Check the config file for unknown keys and invalid values, then print the
+configuration merged from defaults, config file, environment variables and
+flags, with secrets masked. Exits with an error if a problem is found.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		var problems []string
+		if err := initRuntimeConfig(); err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		fmt.Println("Config file:", config.File())
+		var decodeErr *config.DecodeError
+		switch {
+		case errors.As(configLoadErr, &decodeErr):
+			problems = append(problems, decodeErr.Problems...)
+		case errors.Is(configLoadErr, fs.ErrNotExist):
+			fmt.Println("Config file not found, using defaults")
+		case configLoadErr != nil:
+			problems = append(problems, configLoadErr.Error())
+		}
+		problems = append(problems, validateConfig(config.Cfg)...)
+
+		if len(problems) == 0 {
+			fmt.Println("Config is valid")
+		} else {
+			fmt.Println("Problems:")
+			for _, p := range problems {
+				fmt.Println("  - " + p)
+			}
+		}
+
+		fmt.Println()
+		fmt.Println("Effective configuration (secrets masked):")
+		data, err := yaml.Marshal(config.Masked(config.Cfg))
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+
+		if len(problems) > 0 {
+			return fmt.Errorf("config has %d problem(s)", len(problems))
+		}
+		return nil
+	},
+}
+
+// validateConfig checks values that the config file may hold but that are
+// only used later, e.g. when a download starts.
+func validateConfig(cfg config.Config) []string {
+	var problems []string
+	if cfg.ProxyUrl != "" {
+		if err := api.ValidateProxyURL(cfg.ProxyUrl); err != nil {
+			problems = append(problems, "proxyUrl: "+err.Error())
+		}
+	}
+	if cfg.FilenameTemplate != "" {
+		if err := downloader.ValidateTemplate(cfg.FilenameTemplate); err != nil {
+			problems = append(problems, "filenameTemplate: "+err.Error())
+		}
+	}
+	if err := checkWritableDir(cfg.RootDir); err != nil {
+		problems = append(problems, "rootDir: "+err.Error())
+	}
+	if cfg.ThreadNum < 1 {
+		problems = append(problems, "threadNum: must be at least 1")
+	}
+	if err := downloader.ValidateRules(cfg.Rules); err != nil {
+		problems = append(problems, strings.Split(err.Error(), "\n")...)
+	}
+	if err := server.ValidateTokens(cfg); err != nil {
+		problems = append(problems, strings.Split(err.Error(), "\n")...)
+	}
+	if err := hook.Validate(cfg.Hooks); err != nil {
+		problems = append(problems, strings.Split(err.Error(), "\n")...)
+	}
+	return problems
+}
+
+// checkWritableDir confirms dir exists and a file can be created in it.
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(dir + " is not a directory")
+	}
+	f, err := os.CreateTemp(dir, ".iwaradl-write-test-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_ = f.Close()
+	return os.Remove(name)
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
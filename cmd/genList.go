@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"iwaradl/api"
+	"iwaradl/downloader"
+	"iwaradl/filter"
+	"iwaradl/util"
 	"os"
 	"strings"
 	"time"
@@ -38,9 +41,25 @@ var (
 
 	// Minimum required duration in seconds.
 	filterDuration = 90
+
+	// Filter expression replacing the like/view/duration thresholds.
+	filterExpr  = ""
+	videoFilter *filter.Expr
 )
 
-var outputListFile = "videolist.txt"
+// defaultTimezone is used for dates unless --timezone is given.
+const defaultTimezone = "Asia/Shanghai"
+
+var (
+	outputListFile = "videolist.txt"
+
+	// Output file format: txt, json, csv or m3u.
+	outputFormat = "txt"
+
+	// IANA timezone for displayed and exported times, "Local" for local time.
+	outputTimezone = defaultTimezone
+	outputLocation *time.Location
+)
 
 var validSortValues = map[string]struct{}{
 	"date":       {},
@@ -70,6 +89,22 @@ func IsAcceptVideo(v api.VideoInfo) bool {
 	return like >= likeFilter && view >= filterViews && dur >= filterDuration && createAt.After(dateLimitTime)
 }
 
+// acceptVideo applies --filter if given, within the --date-limit window, and
+// the built-in thresholds otherwise.
+func acceptVideo(v api.VideoInfo) (bool, error) {
+	if videoFilter == nil {
+		return IsAcceptVideo(v), nil
+	}
+	if v.CreatedAt.Before(time.Now().AddDate(0, 0, -dateLimit)) {
+		return false, nil
+	}
+	ok, err := videoFilter.Match(v)
+	if err != nil {
+		return false, fmt.Errorf("--filter on video %s: %w", v.Id, err)
+	}
+	return ok, nil
+}
+
 func genVideoList() error {
 	now := time.Now()
 	dateLimitTime := now.AddDate(0, 0, -dateLimit)
@@ -103,35 +138,71 @@ func genVideoList() error {
 	// Filter by engagement + freshness rules.
 	var filteredVideolist []api.VideoInfo
 
-	// Display creation time in local preference.
-	loc, _ := time.LoadLocation("Asia/Shanghai")
-	fmt.Println("      ID      \tLikes\t         Date         \t   Title")
+	loc := outputLocation
+	skipped := 0
+	seen := make(map[string]bool)
 	for _, video := range videolist {
-		if IsAcceptVideo(video) {
+		// list pages may overlap while the ranking on the site shifts
+		if seen[video.Id] {
+			continue
+		}
+		seen[video.Id] = true
+		ok, err := acceptVideo(video)
+		if err != nil {
+			return err
+		}
+		if ok && skipDownloaded && downloader.FindHistory(video.Id) {
+			util.Log.Debug("Skipping downloaded video", "vid", video.Id)
+			skipped++
+			continue
+		}
+		if ok {
 			filteredVideolist = append(filteredVideolist, video)
-			fmt.Printf("%s\t%5d\t%s \t%s\n", video.Id, video.NumLikes, video.CreatedAt.In(loc).Format("2006-01-02 15:04:05"), video.Title)
 		}
 	}
 
-	// Write filtered video URLs to output file.
+	ranked, err := rankVideos(filteredVideolist)
+	if err != nil {
+		return err
+	}
+	if rankMode == "none" {
+		fmt.Println("      ID      \tLikes\t         Date         \t   Title")
+	} else {
+		fmt.Println("      ID      \tLikes\t         Date         \t   Score  \t   Title")
+	}
+	for _, video := range ranked {
+		date := video.CreatedAt.In(loc).Format("2006-01-02 15:04:05")
+		if rankMode == "none" {
+			fmt.Printf("%s\t%5d\t%s \t%s\n", video.Id, video.NumLikes, date, video.Title)
+		} else {
+			fmt.Printf("%s\t%5d\t%s \t%9.4g\t%s\n", video.Id, video.NumLikes, date, video.Score, video.Title)
+		}
+	}
+
+	// Write filtered videos to output file.
+	entries := make([]listEntry, 0, len(ranked))
+	for _, video := range ranked {
+		entries = append(entries, newListEntry(video, loc))
+	}
 	f, err := os.Create(outputListFile)
 	if err != nil {
 		return err
 	}
-	defer func(f *os.File) {
+	if err := writeVideoList(f, outputFormat, entries); err != nil {
 		_ = f.Close()
-	}(f)
-	for _, video := range filteredVideolist {
-		_, err := f.WriteString("https://" + site + "/video/" + video.Id + "\n")
-		if err != nil {
-			return err
-		}
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipped %d already downloaded videos\n", skipped)
 	}
 
-	return nil
+	return enqueueVideos(entries)
 }
 
-func validateGenListParams() error {
+func validateGenListParams(scoreSet bool) error {
 	if site != "www.iwara.tv" && site != "www.iwara.ai" {
 		return fmt.Errorf("invalid --site %q, only www.iwara.tv and www.iwara.ai is supported", site)
 	}
@@ -168,24 +239,58 @@ func validateGenListParams() error {
 		return fmt.Errorf("invalid --filter-duration %d, must be greater than 0", filterDuration)
 	}
 
+	videoFilter = nil
+	if strings.TrimSpace(filterExpr) != "" {
+		expr, err := filter.Parse(filterExpr)
+		if err != nil {
+			return fmt.Errorf("invalid --filter: %w", err)
+		}
+		videoFilter = expr
+	}
+
 	if strings.TrimSpace(outputListFile) == "" {
 		return errors.New("invalid --output, file name cannot be empty")
 	}
 
+	if _, ok := validFormatValues[outputFormat]; !ok {
+		return fmt.Errorf("invalid --format %q, allowed values: txt, json, csv, m3u", outputFormat)
+	}
+
+	if err := validateRankParams(scoreSet); err != nil {
+		return err
+	}
+
+	if err := validateEnqueueParams(); err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(outputTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid --timezone %q: %w", outputTimezone, err)
+	}
+	outputLocation = loc
+
 	return nil
 }
 
 var genListCmd = &cobra.Command{
 	Use:   "genlist",
 	Short: "Generate a filtered Iwara video URL list",
-	Long:  "Query videos from Iwara by sort/rating, apply local filtering rules, and write the resulting video URLs to a text file.",
+	Long:  "Query videos from Iwara by sort/rating, apply local filtering rules, and write the resulting videos to a file as plain URLs, JSON, CSV or an M3U playlist.",
 	Example: "  iwaradl genlist --sort date --page-limit 3 --date-limit 14 --output videolist.txt\n" +
-		"  iwaradl genlist --rating all --filter-like0 200 --filter-like-inc 20 --filter-duration 120",
+		"  iwaradl genlist --rating all --filter-like0 200 --filter-like-inc 20 --filter-duration 120\n" +
+		"  iwaradl genlist --filter 'like_ratio() > 0.05 && duration > 120 && !(\"futa\" in tags)'\n" +
+		"  iwaradl genlist --format csv --timezone Local --output videolist.csv\n" +
+		"  iwaradl genlist --sort date --date-limit 7 --page-limit 5 --rank likes-per-day --top 20 --per-author-max 2\n" +
+		"  iwaradl genlist --score 'likes + views/100 - age_days()*10' --top 20\n" +
+		"  iwaradl genlist --skip-downloaded --enqueue http://127.0.0.1:23456 --enqueue-options '{\"max_retry\":2}'",
 	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		initRuntimeConfig()
+		if err := initRuntimeConfig(); err != nil {
+			return err
+		}
 
-		if err := validateGenListParams(); err != nil {
+		if err := validateGenListParams(cmd.Flags().Changed("score")); err != nil {
 			return err
 		}
 
@@ -204,5 +309,15 @@ func init() {
 	genListCmd.Flags().IntVar(&filterLikeInc, "filter-like-inc", 50, "Extra required likes per day since creation (>= 0)")
 	genListCmd.Flags().IntVar(&filterViews, "filter-views", 0, "Minimum views for each video (>= 0)")
 	genListCmd.Flags().IntVar(&filterDuration, "filter-duration", 90, "Minimum duration in seconds for each video (> 0)")
-	genListCmd.Flags().StringVar(&outputListFile, "output", "videolist.txt", "Output file path for generated video URLs")
+	genListCmd.Flags().StringVar(&filterExpr, "filter", "", "Filter expression, replaces the --filter-like0/--filter-like-inc/--filter-views/--filter-duration thresholds")
+	genListCmd.Flags().StringVar(&outputListFile, "output", "videolist.txt", "Output file path for generated video list")
+	genListCmd.Flags().StringVar(&outputFormat, "format", "txt", "Output file format. Allowed: txt, json, csv, m3u")
+	genListCmd.Flags().StringVar(&rankMode, "rank", "none", "Sort the result by score. Allowed: none, likes-per-day, like-ratio, custom")
+	genListCmd.Flags().StringVar(&scoreExpr, "score", "", "Score expression for --rank custom, e.g. 'likes + views/100'; implies --rank custom")
+	genListCmd.Flags().IntVar(&topN, "top", 0, "Keep only the N best videos (0 for all)")
+	genListCmd.Flags().IntVar(&perAuthorMax, "per-author-max", 0, "Keep at most M videos per author (0 for no limit)")
+	genListCmd.Flags().BoolVar(&skipDownloaded, "skip-downloaded", false, "Leave out videos already in history.list")
+	genListCmd.Flags().StringVar(&enqueueTarget, "enqueue", "", "Also enqueue the result: \"jobs\" appends to jobs.list, a daemon URL posts to its /api/tasks using --api-token")
+	genListCmd.Flags().StringVar(&enqueueOptions, "enqueue-options", "", "Task options as JSON for --enqueue to a daemon, e.g. '{\"download_dir\":\"iwara\"}'")
+	genListCmd.Flags().StringVar(&outputTimezone, "timezone", defaultTimezone, "IANA timezone for displayed and exported times, or Local for local time")
 }
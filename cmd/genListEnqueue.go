@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/server"
+	"iwaradl/server/client"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// Skip videos already recorded in history.list.
+	skipDownloaded = false
+
+	// Where to enqueue the result: "jobs" for rootDir/jobs.list, or a daemon base URL.
+	enqueueTarget = ""
+
+	// Task options for daemon enqueueing, as JSON.
+	enqueueOptions = ""
+	enqueueTaskOpt server.TaskOptions
+)
+
+const enqueueJobs = "jobs"
+
+func validateEnqueueParams() error {
+	enqueueTaskOpt = server.TaskOptions{}
+	if enqueueTarget != "" && enqueueTarget != enqueueJobs {
+		u, err := url.Parse(enqueueTarget)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid --enqueue %q, use %q or a daemon URL like http://127.0.0.1:23456", enqueueTarget, enqueueJobs)
+		}
+	}
+	if strings.TrimSpace(enqueueOptions) == "" {
+		return nil
+	}
+	if enqueueTarget == "" || enqueueTarget == enqueueJobs {
+		return errors.New("--enqueue-options needs --enqueue with a daemon URL")
+	}
+	dec := json.NewDecoder(strings.NewReader(enqueueOptions))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&enqueueTaskOpt); err != nil {
+		return fmt.Errorf("invalid --enqueue-options: %w", err)
+	}
+	return nil
+}
+
+// enqueueVideos hands the videos to the downloader, either by appending them
+// to jobs.list for the next `iwaradl -r` or by posting them to a daemon.
+func enqueueVideos(videos []listEntry) error {
+	if enqueueTarget == "" || len(videos) == 0 {
+		return nil
+	}
+	urls := make([]string, 0, len(videos))
+	for _, v := range videos {
+		urls = append(urls, v.URL)
+	}
+	if enqueueTarget == enqueueJobs {
+		downloader.VidList = nil
+		downloader.LoadVidList()
+		queued := make(map[string]bool, len(downloader.VidList))
+		for _, vid := range downloader.VidList {
+			queued[vid] = true
+		}
+		vids := downloader.ProcessUrlList(urls)
+		var added []string
+		for _, vid := range vids {
+			if !queued[vid] {
+				queued[vid] = true
+				added = append(added, vid)
+			}
+		}
+		if len(added) == 0 {
+			fmt.Println("No new videos, all are already in jobs.list")
+			return nil
+		}
+		downloader.VidList = append(downloader.VidList, added...)
+		downloader.SaveVidList()
+		fmt.Printf("Added %d videos to jobs.list (%d already there), run `iwaradl -r` to download them\n", len(added), len(vids)-len(added))
+		return nil
+	}
+	return postTasks(enqueueTarget, urls, enqueueTaskOpt)
+}
+
+// postTasks enqueues urls on the daemon at base.
+func postTasks(base string, urls []string, opts server.TaskOptions) error {
+	c, err := client.New(base, config.Cfg.ApiToken)
+	if err != nil {
+		return err
+	}
+	tasks, err := c.CreateTasks(context.Background(), urls, opts)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity && apiErr.Message == "no new valid tasks" {
+		fmt.Println("No new tasks, all videos are already queued on the daemon")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("enqueue to daemon: %w", err)
+	}
+	fmt.Printf("Enqueued %d tasks on %s\n", len(tasks), base)
+	return nil
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var validFormatValues = map[string]struct{}{
+	"txt":  {},
+	"json": {},
+	"csv":  {},
+	"m3u":  {},
+}
+
+// listEntry is one video in the genlist output.
+type listEntry struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Author    string   `json:"author"`
+	Likes     int      `json:"likes"`
+	Views     int      `json:"views"`
+	Duration  int      `json:"duration"` // seconds
+	CreatedAt string   `json:"created_at"`
+	Tags      []string `json:"tags"`
+	URL       string   `json:"url"`
+	Score     *float64 `json:"score,omitempty"` // set with --rank
+}
+
+func newListEntry(v rankedVideo, loc *time.Location) listEntry {
+	tags := make([]string, 0, len(v.Tags))
+	for _, t := range v.Tags {
+		tags = append(tags, t.Id)
+	}
+	e := listEntry{
+		ID:        v.Id,
+		Title:     v.Title,
+		Author:    v.User.Username,
+		Likes:     v.NumLikes,
+		Views:     v.NumViews,
+		Duration:  v.File.Duration,
+		CreatedAt: v.CreatedAt.In(loc).Format(time.RFC3339),
+		Tags:      tags,
+		URL:       "https://" + site + "/video/" + v.Id,
+	}
+	if rankMode != "none" {
+		score := v.Score
+		e.Score = &score
+	}
+	return e
+}
+
+// writeVideoList writes entries to w in the given --format.
+func writeVideoList(w io.Writer, format string, entries []listEntry) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "title", "author", "likes", "views", "duration", "created_at", "tags", "url", "score"})
+		for _, e := range entries {
+			score := ""
+			if e.Score != nil {
+				score = strconv.FormatFloat(*e.Score, 'g', -1, 64)
+			}
+			_ = cw.Write([]string{e.ID, e.Title, e.Author, strconv.Itoa(e.Likes), strconv.Itoa(e.Views),
+				strconv.Itoa(e.Duration), e.CreatedAt, strings.Join(e.Tags, ";"), e.URL, score})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "m3u":
+		if _, err := io.WriteString(w, "#EXTM3U\n"); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			title := strings.NewReplacer("\r", " ", "\n", " ").Replace(e.Author + " - " + e.Title)
+			if _, err := fmt.Fprintf(w, "#EXTINF:%d,%s\n%s\n", e.Duration, title, e.URL); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		for _, e := range entries {
+			if _, err := io.WriteString(w, e.URL+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
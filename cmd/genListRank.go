@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"iwaradl/api"
+	"iwaradl/filter"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// Ranking mode: none, likes-per-day, like-ratio or custom.
+	rankMode = "none"
+
+	// Score expression for the custom ranking mode.
+	scoreExpr   = ""
+	scoreFilter *filter.Expr
+
+	// Keep only the N best videos, 0 for all.
+	topN = 0
+
+	// Keep at most M videos per author, 0 for no limit.
+	perAuthorMax = 0
+)
+
+var validRankValues = map[string]struct{}{
+	"none":          {},
+	"likes-per-day": {},
+	"like-ratio":    {},
+	"custom":        {},
+}
+
+// rankedVideo is a video that passed the filter, with its ranking score.
+type rankedVideo struct {
+	api.VideoInfo
+	Score float64
+}
+
+func validateRankParams(scoreSet bool) error {
+	if scoreSet && rankMode == "none" {
+		rankMode = "custom"
+	}
+	if _, ok := validRankValues[rankMode]; !ok {
+		return fmt.Errorf("invalid --rank %q, allowed values: none, likes-per-day, like-ratio, custom", rankMode)
+	}
+	scoreFilter = nil
+	if rankMode == "custom" {
+		if strings.TrimSpace(scoreExpr) == "" {
+			return errors.New("--rank custom needs a --score expression")
+		}
+		expr, err := filter.Parse(scoreExpr)
+		if err != nil {
+			return fmt.Errorf("invalid --score: %w", err)
+		}
+		scoreFilter = expr
+	}
+	if topN < 0 {
+		return fmt.Errorf("invalid --top %d, must be greater than or equal to 0", topN)
+	}
+	if perAuthorMax < 0 {
+		return fmt.Errorf("invalid --per-author-max %d, must be greater than or equal to 0", perAuthorMax)
+	}
+	return nil
+}
+
+// scoreVideo returns the ranking score of v for the current --rank mode.
+func scoreVideo(v api.VideoInfo, now time.Time) (float64, error) {
+	switch rankMode {
+	case "likes-per-day":
+		// count videos younger than a day as one day old, so a fresh upload
+		// with a handful of likes does not top the list
+		days := math.Max(now.Sub(v.CreatedAt).Hours()/24, 1)
+		return float64(v.NumLikes) / days, nil
+	case "like-ratio":
+		if v.NumViews == 0 {
+			return 0, nil
+		}
+		return float64(v.NumLikes) / float64(v.NumViews), nil
+	case "custom":
+		score, err := scoreFilter.Number(v)
+		if err != nil {
+			return 0, fmt.Errorf("--score on video %s: %w", v.Id, err)
+		}
+		return score, nil
+	}
+	return 0, nil
+}
+
+// rankVideos scores and sorts videos best first, keeping the API order
+// without a ranking mode, then applies --per-author-max and --top.
+func rankVideos(videos []api.VideoInfo) ([]rankedVideo, error) {
+	now := time.Now()
+	ranked := make([]rankedVideo, 0, len(videos))
+	for _, v := range videos {
+		score, err := scoreVideo(v, now)
+		if err != nil {
+			return nil, err
+		}
+		ranked = append(ranked, rankedVideo{VideoInfo: v, Score: score})
+	}
+	if rankMode != "none" {
+		slices.SortStableFunc(ranked, func(a, b rankedVideo) int {
+			switch {
+			case a.Score > b.Score:
+				return -1
+			case a.Score < b.Score:
+				return 1
+			}
+			return 0
+		})
+	}
+
+	if perAuthorMax > 0 {
+		perAuthor := make(map[string]int)
+		kept := ranked[:0]
+		for _, v := range ranked {
+			author := strings.ToLower(v.User.Username)
+			if perAuthor[author] >= perAuthorMax {
+				continue
+			}
+			perAuthor[author]++
+			kept = append(kept, v)
+		}
+		ranked = kept
+	}
+	if topN > 0 && len(ranked) > topN {
+		ranked = ranked[:topN]
+	}
+	return ranked, nil
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"iwaradl/api"
+	"iwaradl/config"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var accountSite string
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to iwara and store the authorization token",
+	Long: `Prompt for email and password, log in to iwara and store the returned
+authorization token in the credentials file. The password is never saved.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := initRuntimeConfig(); err != nil {
+			return err
+		}
+
+		reader := bufio.NewReader(os.Stdin)
+		user := email
+		if user == "" {
+			fmt.Print("Email: ")
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				return err
+			}
+			user = strings.TrimSpace(line)
+		}
+		if user == "" {
+			return errors.New("email cannot be empty")
+		}
+
+		pass := password
+		if pass == "" {
+			var err error
+			pass, err = readPassword(reader)
+			if err != nil {
+				return err
+			}
+		}
+		if pass == "" {
+			return errors.New("password cannot be empty")
+		}
+
+		if err := api.Login(user, pass, accountSite); err != nil {
+			return fmt.Errorf("login failed: %w", err)
+		}
+		acc := currentAccount()
+		fmt.Printf("Logged in as %s, token saved to %s\n", user, acc.CredentialsFile)
+		return nil
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the stored authorization token",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := initRuntimeConfig(); err != nil {
+			return err
+		}
+		if err := api.Logout(); err != nil {
+			return err
+		}
+		acc := currentAccount()
+		fmt.Println("Logged out, removed " + acc.CredentialsFile)
+		if acc.Authorization != "" {
+			fmt.Println("Note: an authorization token is still set in the config file or on the command line")
+		}
+		return nil
+	},
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the logged-in iwara account",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := initRuntimeConfig(); err != nil {
+			return err
+		}
+		profile, err := api.GetCurrentUser(accountSite)
+		if err != nil {
+			var httpErr *api.HTTPError
+			if errors.As(err, &httpErr) && httpErr.StatusCode == 401 {
+				return errors.New("not logged in, run `iwaradl login` first")
+			}
+			return err
+		}
+		premium := "no"
+		if profile.User.Premium {
+			premium = "yes"
+		}
+		fmt.Printf("Logged in as %s (@%s)\n", profile.User.Name, profile.User.Username)
+		fmt.Printf("User ID: %s\n", profile.User.Id)
+		fmt.Printf("Premium: %s\n", premium)
+		return nil
+	},
+}
+
+// currentAccount returns the credentials of the account selected by --account.
+func currentAccount() config.Account {
+	acc, _ := config.Cfg.LookupAccount(api.Default().Account())
+	return acc
+}
+
+// readPassword reads a password without echo from a terminal, or a plain
+// line when stdin is piped.
+func readPassword(reader *bufio.Reader) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Print("Password: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd, logoutCmd, whoamiCmd)
+	for _, c := range []*cobra.Command{loginCmd, whoamiCmd} {
+		c.Flags().StringVar(&accountSite, "site", "www.iwara.tv", "Site to log in to. Allowed: www.iwara.tv, www.iwara.ai")
+	}
+}
@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/server"
+	"iwaradl/server/client"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+const defaultDaemonURL = "http://127.0.0.1:23456"
+
+var (
+	daemonURL      string
+	remoteDir      string
+	remoteCookie   string
+	remoteJSON     bool
+	remotePriority int
+	remoteInterval time.Duration
+	remoteQuery    server.TaskQuery
+	remoteSince    string
+)
+
+var remoteCmd = &cobra.Command{
+	Use:   "remote",
+	Short: "Drive a running iwaradl daemon",
+	Long: `Send downloads to a running daemon and follow them instead of downloading
+locally. The daemon URL comes from --daemon, daemonUrl in the config or
+IWARADL_DAEMON_URL, and the API token from --api-token, apiToken in the config
+or IWARADL_API_TOKEN.`,
+}
+
+var remoteAddCmd = &cobra.Command{
+	Use:   "add [URL...]",
+	Short: "Create tasks on the daemon",
+	Long: `Create tasks on the daemon from video or user page URLs and a URL list file.
+--proxy-url, --filename-template, --max-retry, --limit-rate and --account are
+sent as task options; --account names an account in the daemon's config.`,
+	Example: "  iwaradl remote add https://www.iwara.tv/video/xxxx --download-dir 'iwara/{{author}}' --max-retry 2\n" +
+		"  iwaradl remote add -l videolist.txt --daemon http://nas:23456",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, taskAccount, err := newRemoteClient()
+		if err != nil {
+			return err
+		}
+		urls := append([]string{}, args...)
+		if listFile != "" {
+			data, err := os.ReadFile(listFile)
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					urls = append(urls, line)
+				}
+			}
+		}
+		if len(urls) == 0 {
+			return errors.New("no URL given")
+		}
+		opts := server.TaskOptions{
+			ProxyURL:         proxyUrl,
+			DownloadDir:      remoteDir,
+			Cookie:           remoteCookie,
+			FilenameTemplate: filenameTemplate,
+			RateLimit:        limitRate,
+			Account:          taskAccount,
+			Priority:         remotePriority,
+		}
+		if maxRetry > 0 {
+			opts.MaxRetry = maxRetry
+		}
+		tasks, err := c.CreateTasks(context.Background(), urls, opts)
+		if err != nil {
+			return err
+		}
+		return printTasks(tasks)
+	},
+}
+
+var remoteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the daemon's tasks",
+	Long: `List the daemon's tasks, oldest first unless --sort or --order say otherwise.
+Every matching task is fetched, page by page. With --limit only one page is
+printed, followed by the cursor of the next page.`,
+	Example: "  iwaradl remote list --status failed,pending --since 24h\n" +
+		"  iwaradl remote list --sort priority --limit 20",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		q := remoteQuery
+		if remoteSince != "" {
+			if d, err := time.ParseDuration(remoteSince); err == nil && d > 0 {
+				q.Since = time.Now().Add(-d)
+			} else if t, err := time.Parse(time.RFC3339, remoteSince); err == nil {
+				q.Since = t
+			} else {
+				return fmt.Errorf("invalid --since %q, use a duration like 24h or an RFC 3339 time", remoteSince)
+			}
+		}
+		c, _, err := newRemoteClient()
+		if err != nil {
+			return err
+		}
+		var list server.TaskListResp
+		if q.Limit > 0 {
+			list, err = c.ListTasks(context.Background(), q)
+		} else {
+			list, err = c.ListAllTasks(context.Background(), q)
+		}
+		if err != nil {
+			return err
+		}
+		if remoteJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(list)
+		}
+		if err := printTasks(list.Tasks); err != nil {
+			return err
+		}
+		if list.NextCursor != "" {
+			fmt.Printf("\n%d of %d tasks, next page: --cursor %s\n", len(list.Tasks), list.Total, list.NextCursor)
+		}
+		return nil
+	},
+}
+
+var remoteGetCmd = &cobra.Command{
+	Use:   "get VID...",
+	Short: "Show tasks in detail",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, _, err := newRemoteClient()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		for _, arg := range args {
+			task, err := c.GetTask(context.Background(), taskID(arg))
+			if err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+			if err := enc.Encode(task); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var remoteCancelCmd = &cobra.Command{
+	Use:   "cancel VID...",
+	Short: "Cancel pending or running tasks on the daemon",
+	Long: `Cancel tasks on the daemon. Pending tasks do not start, and a running task
+stops its download and keeps the part file. Cancelled tasks stay in the list
+as failed and can be retried.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, _, err := newRemoteClient()
+		if err != nil {
+			return err
+		}
+		vids := make([]string, len(args))
+		for i, arg := range args {
+			vids[i] = taskID(arg)
+		}
+		resp, err := c.BulkTasks(context.Background(), server.BulkReq{Action: server.BulkCancel, VIDs: vids})
+		if err != nil {
+			return err
+		}
+		for _, r := range resp.Results {
+			if r.OK {
+				fmt.Println("Cancelled", r.VID)
+			} else {
+				fmt.Fprintln(os.Stderr, r.VID+":", r.Error)
+			}
+		}
+		if resp.Failed > 0 {
+			return fmt.Errorf("%d of %d tasks not cancelled", resp.Failed, len(vids))
+		}
+		return nil
+	},
+}
+
+var remoteWatchCmd = &cobra.Command{
+	Use:   "watch [VID...]",
+	Short: "Follow task progress until the tasks finish",
+	Long: `Print status and progress changes of the given tasks, or of all tasks,
+until none of them is pending or running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if remoteInterval <= 0 {
+			return fmt.Errorf("invalid --interval %s, must be greater than 0", remoteInterval)
+		}
+		c, _, err := newRemoteClient()
+		if err != nil {
+			return err
+		}
+		watched := make(map[string]bool)
+		for _, arg := range args {
+			watched[taskID(arg)] = true
+		}
+		return watchTasks(c, watched)
+	},
+}
+
+// newRemoteClient loads the local config for the daemon URL and token. It
+// returns --account separately: it names an account of the daemon, which
+// need not exist in the local config.
+func newRemoteClient() (*client.Client, string, error) {
+	taskAccount := account
+	account = ""
+	if err := initRuntimeConfig(); err != nil {
+		return nil, "", err
+	}
+	base := daemonURL
+	if base == "" {
+		base = config.Cfg.DaemonUrl
+	}
+	if base == "" {
+		base = defaultDaemonURL
+	}
+	c, err := client.New(base, config.Cfg.ApiToken)
+	return c, taskAccount, err
+}
+
+// taskID turns a video URL or bare video ID into the daemon's task ID.
+func taskID(arg string) string {
+	if strings.Contains(arg, "/") {
+		if vid, _, host, err := downloader.ParseUrl(arg); err == nil && vid != "" {
+			return vid + "@" + host
+		}
+	}
+	if !strings.Contains(arg, "@") {
+		return arg + "@www.iwara.tv"
+	}
+	return arg
+}
+
+func printTasks(tasks []server.TaskResp) error {
+	if remoteJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tasks)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VID\tSTATUS\tPROGRESS\tATTEMPTS\tERROR\tCREATED\tTITLE")
+	for _, t := range tasks {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%.0f%%\t%d\t%s\t%s\t%s\n", t.VID, t.Status, t.Progress*100, t.Attempts, t.ErrorClass,
+			t.CreatedAt.Local().Format("2006-01-02 15:04:05"), t.Title)
+	}
+	return tw.Flush()
+}
+
+func taskActive(t server.TaskResp) bool {
+	return t.Status == "pending" || t.Status == "running"
+}
+
+func watchTasks(c *client.Client, watched map[string]bool) error {
+	type state struct {
+		status  string
+		percent int
+	}
+	last := make(map[string]state)
+	for {
+		list, err := c.ListAllTasks(context.Background(), server.TaskQuery{})
+		if err != nil {
+			return err
+		}
+		tasks := list.Tasks
+		active := 0
+		found := make(map[string]bool)
+		for _, t := range tasks {
+			if len(watched) > 0 && !watched[t.VID] {
+				continue
+			}
+			found[t.VID] = true
+			cur := state{t.Status, int(t.Progress * 100)}
+			prev, ok := last[t.VID]
+			last[t.VID] = cur
+			if !ok && len(watched) == 0 && !taskActive(t) {
+				// when watching all tasks, leave out those finished before
+				continue
+			}
+			if !ok || prev != cur {
+				line := fmt.Sprintf("%s %s %s %d%%", time.Now().Format("15:04:05"), t.VID, t.Status, cur.percent)
+				if t.Status == "running" && t.Speed > 0 {
+					line += " " + humanize.Bytes(uint64(t.Speed)) + "/s"
+				}
+				if t.Status == "failed" && t.LastError != "" {
+					line += " " + t.ErrorClass + ": " + t.LastError
+				}
+				fmt.Println(line)
+			}
+			if taskActive(t) {
+				active++
+			}
+		}
+		for vid := range watched {
+			if !found[vid] {
+				return fmt.Errorf("%s: task not found", vid)
+			}
+		}
+		if active == 0 {
+			return nil
+		}
+		time.Sleep(remoteInterval)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(remoteCmd)
+	remoteCmd.AddCommand(remoteAddCmd, remoteListCmd, remoteGetCmd, remoteCancelCmd, remoteWatchCmd)
+	remoteCmd.PersistentFlags().StringVar(&daemonURL, "daemon", "", "daemon URL (default daemonUrl from config, or "+defaultDaemonURL+")")
+	remoteAddCmd.Flags().StringVar(&remoteDir, "download-dir", "", "download directory on the daemon, absolute or relative to its rootDir")
+	remoteAddCmd.Flags().StringVar(&remoteCookie, "cookie", "", "cookie for this task only")
+	remoteAddCmd.Flags().BoolVar(&remoteJSON, "json", false, "print the created tasks as JSON")
+	remoteAddCmd.Flags().IntVar(&remotePriority, "priority", 0, "tasks with a higher priority are downloaded first")
+	remoteListCmd.Flags().BoolVar(&remoteJSON, "json", false, "print the tasks as JSON")
+	remoteListCmd.Flags().StringSliceVar(&remoteQuery.Statuses, "status", nil, "only tasks with these statuses (pending, running, completed, failed, skipped)")
+	remoteListCmd.Flags().StringSliceVar(&remoteQuery.ErrorClasses, "error-class", nil, "only tasks whose last error has these classes (e.g. forbidden)")
+	remoteListCmd.Flags().StringVar(&remoteQuery.Author, "author", "", "only tasks of this author username")
+	remoteListCmd.Flags().StringVarP(&remoteQuery.Q, "search", "q", "", "only tasks whose title or ID contains this text")
+	remoteListCmd.Flags().StringVar(&remoteSince, "since", "", "only tasks created within this duration (e.g. 24h) or since this RFC 3339 time")
+	remoteListCmd.Flags().StringVar(&remoteQuery.Sort, "sort", "", "sort by created_at (default), priority or progress")
+	remoteListCmd.Flags().StringVar(&remoteQuery.Order, "order", "", "asc or desc (default asc for created_at, desc otherwise)")
+	remoteListCmd.Flags().IntVar(&remoteQuery.Limit, "limit", 0, fmt.Sprintf("print one page of at most this many tasks, up to %d (0 for all tasks)", server.MaxTaskPageSize))
+	remoteListCmd.Flags().StringVar(&remoteQuery.Cursor, "cursor", "", "continue after the page that printed this cursor")
+	remoteWatchCmd.Flags().DurationVar(&remoteInterval, "interval", time.Second, "how often to poll the daemon")
+}
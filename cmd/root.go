@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
+	"iwaradl/api"
 	"iwaradl/config"
 	"iwaradl/downloader"
+	"iwaradl/hook"
 	"iwaradl/util"
 	"os"
 	"strings"
@@ -29,9 +33,19 @@ var (
 	filenameTemplate string
 	threadNum        int
 	maxRetry         int
+	limitRate        string
+	account          string
+	configLoadErr    error // problem loading the config file, reported by config validate
+	logLevel         string
+	logFormat        string
+	logFile          string
+	progress         string
+	reportMarkdown   bool
 )
 
 // rootCmd represents the base command
+const defaultConfigFile = "config.yaml"
+
 var rootCmd = &cobra.Command{
 	Use:   "iwaradl [flags] [URL...]",
 	Short: "A downloader for iwara.tv",
@@ -48,7 +62,9 @@ var rootCmd = &cobra.Command{
 			return cmd.Help()
 		}
 
-		initRuntimeConfig()
+		if err := initRuntimeConfig(); err != nil {
+			return err
+		}
 
 		if updateNfo {
 			if rootDir == "" {
@@ -85,67 +101,159 @@ var rootCmd = &cobra.Command{
 		}
 		downloader.SaveVidList()
 
+		// completed hooks fire right away, failed ones only once retries are exhausted
+		lastFailure := make(map[string]downloader.ProgressReport)
+		reporter := downloader.NewReporter("cli")
+		downloader.SetProgressHook(func(report downloader.ProgressReport) {
+			reporter.Observe(report)
+			if !report.Done {
+				return
+			}
+			if report.Skipped {
+				delete(lastFailure, report.VID)
+			} else if report.Success {
+				delete(lastFailure, report.VID)
+				hook.Fire(config.Cfg.Hooks, hook.FromReport(hook.EventCompleted, report))
+			} else {
+				lastFailure[report.VID] = report
+			}
+		})
+
 		failed := len(downloader.VidList)
 		util.DebugLog("Starting download with %d videos", failed)
 		for i := 0; i < config.Cfg.MaxRetry && failed > 0; i++ {
-			util.DebugLog("Download attempt %d/%d", i+1, config.Cfg.MaxRetry)
+			util.Log.Debug("Download attempt", "attempt", i+1, "max_retry", config.Cfg.MaxRetry)
 			failed = downloader.ConcurrentDownload()
 			if failed > 0 && i < config.Cfg.MaxRetry-1 {
-				util.DebugLog("%d videos failed to download, waiting 30s before retry", failed)
+				util.Log.Warn("Some videos failed to download, retrying in 30s", "failed", failed, "attempt", i+1)
 				time.Sleep(30 * time.Second)
 			}
 		}
 
+		for _, report := range lastFailure {
+			hook.Fire(config.Cfg.Hooks, hook.FromReport(hook.EventFailed, report))
+		}
+		if !reporter.Empty() {
+			if path, err := reporter.Write(config.Cfg.RootDir, config.Cfg.ReportMarkdown); err != nil {
+				util.Log.Error("Failed to write run report", "err", err)
+			} else {
+				util.Log.Info("Run report written", "file", path)
+			}
+		}
+		hook.Wait()
 		return nil
 	},
 }
 
-func initRuntimeConfig() {
-	util.DebugLog("Loading config from file: %s", configFile)
-	err := config.LoadConfig(&config.Cfg, configFile)
-	if err != nil {
-		util.DebugLog("Failed to load config: %v", err)
+func initRuntimeConfig() error {
+	loadErr := config.LoadConfig(&config.Cfg, configFile)
+	if errors.Is(loadErr, fs.ErrNotExist) && configFile == defaultConfigFile {
+		// running without the default config.yaml is fine
+		loadErr = nil
 	}
+	configLoadErr = loadErr
 
 	if debug {
 		util.Debug = true
 	}
+	flagErr := applyFlags(&config.Cfg)
+	if err := util.InitLogger(util.LogOptions{
+		Level:      config.Cfg.LogLevel,
+		Format:     config.Cfg.LogFormat,
+		File:       config.Cfg.LogFile,
+		MaxSizeMB:  config.Cfg.LogMaxSize,
+		MaxBackups: config.Cfg.LogMaxBackups,
+	}); err != nil {
+		return err
+	}
+	if loadErr != nil {
+		util.Log.Warn("Failed to load config", "file", configFile, "err", loadErr)
+	}
 
-	util.DebugLog("Processing command line flags")
-	if rootDir != "" {
-		util.DebugLog("Using root directory from flag: %s", rootDir)
-		config.Cfg.RootDir = rootDir
+	if flagErr != nil {
+		return flagErr
 	}
-	if useSubDir {
-		config.Cfg.UseSubDir = useSubDir
+	if err := downloader.SetProgressMode(config.Cfg.Progress); err != nil {
+		return err
+	}
+
+	rate, err := downloader.ParseRateLimit(config.Cfg.RateLimit)
+	if err != nil {
+		return err
 	}
-	if config.Cfg.ApiToken == "" {
-		config.Cfg.ApiToken = os.Getenv("IWARADL_API_TOKEN")
+	downloader.SetGlobalRateLimit(rate)
+	return api.UseAccount(account)
+}
+
+// applyFlags overrides cfg with the command line flags that were set.
+func applyFlags(cfg *config.Config) error {
+	if logLevel != "" {
+		cfg.LogLevel = logLevel
+	}
+	if logFormat != "" {
+		cfg.LogFormat = logFormat
+	}
+	if logFile != "" {
+		cfg.LogFile = logFile
 	}
-	if email != "" {
-		config.Cfg.Email = email
+	if rootDir != "" {
+		cfg.RootDir = rootDir
 	}
-	if password != "" {
-		config.Cfg.Password = password
+	if useSubDir {
+		cfg.UseSubDir = useSubDir
 	}
-	if auth != "" {
-		config.Cfg.Authorization = auth
+	if account != "" {
+		// credential flags apply to the selected account
+		acc, ok := cfg.Accounts[account]
+		if !ok {
+			return fmt.Errorf("unknown account: %s", account)
+		}
+		if email != "" {
+			acc.Email = email
+		}
+		if password != "" {
+			acc.Password = password
+		}
+		if auth != "" {
+			acc.Authorization = auth
+		}
+		cfg.Accounts[account] = acc
+	} else {
+		if email != "" {
+			cfg.Email = email
+		}
+		if password != "" {
+			cfg.Password = password
+		}
+		if auth != "" {
+			cfg.Authorization = auth
+		}
 	}
 	if apiToken != "" {
-		config.Cfg.ApiToken = apiToken
+		cfg.ApiToken = apiToken
 	}
 	if proxyUrl != "" {
-		config.Cfg.ProxyUrl = proxyUrl
+		cfg.ProxyUrl = proxyUrl
 	}
 	if filenameTemplate != "" {
-		config.Cfg.FilenameTemplate = filenameTemplate
+		cfg.FilenameTemplate = filenameTemplate
 	}
 	if threadNum > 0 {
-		config.Cfg.ThreadNum = threadNum
+		cfg.ThreadNum = threadNum
 	}
 	if maxRetry > 0 {
-		config.Cfg.MaxRetry = maxRetry
+		cfg.MaxRetry = maxRetry
+	}
+	if limitRate != "" {
+		cfg.RateLimit = limitRate
+	}
+	if progress != "" {
+		cfg.Progress = progress
+	}
+	if reportMarkdown {
+		cfg.ReportMarkdown = reportMarkdown
 	}
+	return nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -157,20 +265,27 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "config.yaml", "config file")
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", defaultConfigFile, "config file")
 	rootCmd.PersistentFlags().StringVarP(&listFile, "list-file", "l", "", "URL list file")
 	rootCmd.PersistentFlags().BoolVarP(&resumeJob, "resume", "r", false, "resume unfinished job")
 	rootCmd.PersistentFlags().BoolVar(&updateNfo, "update-nfo", false, "update nfo files in root directory")
 	rootCmd.PersistentFlags().IntVar(&updateDelay, "update-delay", 1, "delay in seconds between updating each nfo file (default: 1)")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug logging")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level: debug, info, warn, error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "log format: text, json")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "write logs to this file with rotation instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&progress, "progress", "", "progress output: auto, tty, plain, json, none (default auto)")
+	rootCmd.PersistentFlags().BoolVar(&reportMarkdown, "report-markdown", false, "also write run reports as Markdown")
 	rootCmd.PersistentFlags().StringVar(&rootDir, "root-dir", "", "root directory for videos")
 	rootCmd.PersistentFlags().BoolVar(&useSubDir, "use-sub-dir", false, "use user name as sub directory")
 	rootCmd.PersistentFlags().StringVarP(&email, "email", "u", "", "username for authentication")
 	rootCmd.PersistentFlags().StringVarP(&password, "password", "p", "", "password for authentication")
 	rootCmd.PersistentFlags().StringVar(&auth, "auth-token", "", "authorization token")
+	rootCmd.PersistentFlags().StringVar(&account, "account", "", "named account from the accounts section of the config")
 	rootCmd.PersistentFlags().StringVar(&apiToken, "api-token", "", "token for daemon HTTP API authentication")
 	rootCmd.PersistentFlags().StringVar(&proxyUrl, "proxy-url", "", "proxy url")
 	rootCmd.PersistentFlags().StringVar(&filenameTemplate, "filename-template", "", "output filename template")
 	rootCmd.PersistentFlags().IntVar(&threadNum, "thread-num", -1, "concurrent download thread number")
 	rootCmd.PersistentFlags().IntVar(&maxRetry, "max-retry", -1, "max retry times")
+	rootCmd.PersistentFlags().StringVar(&limitRate, "limit-rate", "", "bandwidth limit shared by all downloads, e.g. 5M")
 }
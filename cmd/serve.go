@@ -3,8 +3,10 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"iwaradl/config"
 	"iwaradl/server"
+	"iwaradl/util"
 
 	"github.com/spf13/cobra"
 )
@@ -16,18 +18,41 @@ var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "start iwara downloading daemon",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		initRuntimeConfig()
+		if err := initRuntimeConfig(); err != nil {
+			return err
+		}
 		if port <= 0 || port > 65535 {
 			return fmt.Errorf("invalid port: %d", port)
 		}
-		if config.Cfg.ApiToken == "" {
-			return errors.New("api token is required in daemon mode, set --api-token or IWARADL_API_TOKEN")
+		if config.Cfg.ApiToken == "" && len(config.Cfg.ApiTokens) == 0 {
+			return errors.New("api token is required in daemon mode, set --api-token, IWARADL_API_TOKEN, IWARADL_API_TOKEN_FILE or apiTokens in the config")
+		}
+		if err := server.ValidateTokens(config.Cfg); err != nil {
+			return err
 		}
-		fmt.Printf("Starting iwaradl daemon at %s:%d\n", bindAddr, port)
+		server.SetConfigLoader(reloadConfig)
+		util.Log.Info("Starting iwaradl daemon", "bind", bindAddr, "port", port)
 		return server.RunServer(bindAddr, port)
 	},
 }
 
+// reloadConfig reads the configuration again for a daemon reload, with the
+// same precedence of defaults, file, environment and flags as at startup.
+// Unknown keys only warn, as they do at startup.
+func reloadConfig() (config.Config, error) {
+	cfg := config.Defaults()
+	err := config.LoadConfig(&cfg, configFile)
+	var decodeErr *config.DecodeError
+	if errors.As(err, &decodeErr) && decodeErr.UnknownKeysOnly() {
+		util.Log.Warn("Config file has unknown keys", "file", configFile, "err", err)
+		err = nil
+	}
+	if err != nil && !(errors.Is(err, fs.ErrNotExist) && configFile == defaultConfigFile) {
+		return cfg, err
+	}
+	return cfg, applyFlags(&cfg)
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.Flags().StringVar(&bindAddr, "bind", "127.0.0.1", "listen address for daemon mode")
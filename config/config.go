@@ -2,7 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,8 +18,16 @@ var Cfg Config
 var configFile = "config.yaml"
 
 func init() {
+	Cfg = Defaults()
+
+	// 尝试加载配置文件，如果文件不存在则使用默认值
+	_ = LoadConfig(&Cfg)
+}
+
+// Defaults returns the configuration used when a key is not set anywhere.
+func Defaults() Config {
 	// 设置默认值
-	Cfg = Config{
+	return Config{
 		RootDir:          ".",                      // 文件下载根目录
 		UseSubDir:        false,                    // 是否使用子目录(按作者分类)
 		Email:            "",                       // 用户名
@@ -21,28 +35,94 @@ func init() {
 		Authorization:    "",                       // API授权令牌
 		ProxyUrl:         "",                       // 代理服务器地址
 		ApiToken:         "",                       // daemon HTTP API token
+		DaemonUrl:        "",                       // daemon used by the remote commands
 		FilenameTemplate: "{{title}}-{{video_id}}", // output filename template
 		ThreadNum:        3,                        // 下载线程数
 		MaxRetry:         3,                        // 最大重试次数
+		RateLimit:        "",                       // 全局下载限速，如 5M，为空不限速
+		LogLevel:         "info",                   // 日志级别
+		LogFormat:        "text",                   // 日志格式 text / json
+		LogFile:          "",                       // 日志文件，为空输出到 stderr
+		LogMaxSize:       10,                       // 日志文件轮转大小(MB)
+		LogMaxBackups:    3,                        // 保留的轮转日志数
+		Progress:         "auto",                   // 进度输出模式 auto / tty / plain / json / none
+		ReportMarkdown:   false,                    // 运行报告是否额外输出 Markdown
+		CredentialsFile:  "",                       // 令牌缓存文件，为空则为配置文件同目录的 credentials.json
 	}
-
-	// 尝试加载配置文件，如果文件不存在则使用默认值
-	_ = LoadConfig(&Cfg)
 }
 
 type Config struct {
-	RootDir          string `yaml:"rootDir"`
-	UseSubDir        bool   `yaml:"useSubDir"`
-	Email            string `yaml:"email"`
-	Password         string `yaml:"password"`
-	Authorization    string `yaml:"authorization"`
-	ProxyUrl         string `yaml:"proxyUrl"`
-	ApiToken         string `yaml:"apiToken"`
-	FilenameTemplate string `yaml:"filenameTemplate"`
-	ThreadNum        int    `yaml:"threadNum"`
-	MaxRetry         int    `yaml:"maxRetry"`
+	RootDir          string              `yaml:"rootDir"`
+	UseSubDir        bool                `yaml:"useSubDir"`
+	Email            string              `yaml:"email"`
+	Password         string              `yaml:"password"`
+	Authorization    string              `yaml:"authorization"`
+	ProxyUrl         string              `yaml:"proxyUrl"`
+	ApiToken         string              `yaml:"apiToken"`
+	ApiTokens        map[string]ApiToken `yaml:"apiTokens,omitempty"`
+	DaemonUrl        string              `yaml:"daemonUrl,omitempty"`
+	FilenameTemplate string              `yaml:"filenameTemplate"`
+	ThreadNum        int                 `yaml:"threadNum"`
+	MaxRetry         int                 `yaml:"maxRetry"`
+	RateLimit        string              `yaml:"rateLimit"`
+	Hooks            []Hook              `yaml:"hooks,omitempty"`
+	LogLevel         string              `yaml:"logLevel"`
+	LogFormat        string              `yaml:"logFormat"`
+	LogFile          string              `yaml:"logFile"`
+	LogMaxSize       int                 `yaml:"logMaxSize"`
+	LogMaxBackups    int                 `yaml:"logMaxBackups"`
+	Progress         string              `yaml:"progress"`
+	ReportMarkdown   bool                `yaml:"reportMarkdown"`
+	CredentialsFile  string              `yaml:"credentialsFile"`
+	Accounts         map[string]Account  `yaml:"accounts,omitempty"`
+	Rules            []Rule              `yaml:"rules,omitempty"`
+}
+
+// Rule overrides how matching videos are downloaded. Every match field that is
+// set must match; the first matching rule wins.
+type Rule struct {
+	Name             string   `yaml:"name,omitempty"`
+	Authors          []string `yaml:"authors,omitempty"` // author usernames, any of them
+	Tags             []string `yaml:"tags,omitempty"`    // any of them
+	Rating           string   `yaml:"rating,omitempty"`  // general / ecchi
+	Title            string   `yaml:"title,omitempty"`   // regular expression
+	Action           string   `yaml:"action,omitempty"`  // allow (default) / skip
+	DownloadDir      string   `yaml:"downloadDir,omitempty"`
+	FilenameTemplate string   `yaml:"filenameTemplate,omitempty"`
+	Quality          string   `yaml:"quality,omitempty"` // Source, 540, 360, ...
+}
+
+// Account is a named set of iwara credentials selectable with --account or
+// the account task option.
+type Account struct {
+	Email           string `yaml:"email,omitempty"`
+	Password        string `yaml:"password,omitempty"`
+	Authorization   string `yaml:"authorization,omitempty"`
+	CredentialsFile string `yaml:"credentialsFile,omitempty"` // default credentials-<name>.json next to the config file
 }
 
+// ApiToken is a named daemon API token limited to some scopes. The
+// restrictions apply to the tasks it creates.
+type ApiToken struct {
+	Token             string   `yaml:"token"`
+	Scopes            []string `yaml:"scopes"`                      // read / enqueue / admin
+	DownloadDirPrefix string   `yaml:"downloadDirPrefix,omitempty"` // absolute, or relative to rootDir
+	NoCustomProxy     bool     `yaml:"noCustomProxy,omitempty"`     // reject the proxy_url task option
+	RateLimit         string   `yaml:"rateLimit,omitempty"`         // upper bound of the rate_limit task option
+}
+
+// Hook runs a shell command and/or posts a webhook when a download finishes.
+type Hook struct {
+	Events  []string `yaml:"events,omitempty"` // completed / failed, empty means both
+	Command string   `yaml:"command,omitempty"`
+	Webhook string   `yaml:"webhook,omitempty"`
+	Retries int      `yaml:"retries,omitempty"` // webhook retries, default 3
+}
+
+// LoadConfig reads the config file and then applies the IWARADL_* environment
+// variables on top. Unknown keys are reported as a *DecodeError while the
+// known ones are still applied; a missing file is reported with an error
+// matching fs.ErrNotExist and leaves the defaults in place.
 func LoadConfig(cfg *Config, cfgfile ...string) error {
 	if cfg == nil {
 		return errors.New("config pointer cannot be nil")
@@ -53,21 +133,107 @@ func LoadConfig(cfg *Config, cfgfile ...string) error {
 		file = cfgfile[0]
 		configFile = file
 	}
+	fileErr := decodeFile(file, cfg)
+	if err := ApplyEnv(cfg); err != nil {
+		return err
+	}
+	return fileErr
+}
+
+// File returns the path of the config file in use.
+func File() string {
+	return configFile
+}
+
+// DecodeError lists the problems found in a config file, with line numbers.
+type DecodeError struct {
+	File     string
+	Problems []string
+
+	unknownOnly bool // every problem is an unknown key
+}
+
+// UnknownKeysOnly reports whether the only problems are unknown keys, in
+// which case every known key of the file was applied.
+func (e *DecodeError) UnknownKeysOnly() bool {
+	return e.unknownOnly
+}
+
+func (e *DecodeError) Error() string {
+	return "failed to decode config file: " + e.File + ". Error: " + strings.Join(e.Problems, "; ")
+}
+
+var unknownFieldPattern = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+func decodeFile(file string, cfg *Config) error {
 	f, err := os.Open(file)
 	if err != nil {
-		// 任何错误都使用默认配置
-		return nil
+		return fmt.Errorf("config file %s: %w", file, err)
 	}
 	defer func(f *os.File) {
 		_ = f.Close()
 	}(f)
 	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
 	err = decoder.Decode(cfg)
-	if err != nil {
-		return errors.New("failed to decode config file: " + file + ". Error: " + err.Error())
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
 	}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		decodeErr := &DecodeError{File: file, Problems: make([]string, len(typeErr.Errors)), unknownOnly: true}
+		for i, p := range typeErr.Errors {
+			decodeErr.Problems[i] = unknownFieldPattern.ReplaceAllString(p, "unknown field $1")
+			decodeErr.unknownOnly = decodeErr.unknownOnly && unknownFieldPattern.MatchString(p)
+		}
+		return decodeErr
+	}
+	return &DecodeError{File: file, Problems: []string{err.Error()}}
+}
 
-	return nil
+// CredentialsPath returns the file that caches authorization and access tokens.
+func CredentialsPath() string {
+	return Cfg.credentialsPath()
+}
+
+func (c *Config) credentialsPath() string {
+	if c.CredentialsFile != "" {
+		return c.CredentialsFile
+	}
+	return filepath.Join(filepath.Dir(configFile), "credentials.json")
+}
+
+// ChangedKeys returns the keys whose values differ between a and b.
+func ChangedKeys(a Config, b Config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var keys []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			keys = append(keys, yamlKey(va.Type().Field(i)))
+		}
+	}
+	return keys
+}
+
+// LookupAccount returns the credentials of a named account. The empty name is
+// the default account made of the top-level email/password/authorization.
+func (c *Config) LookupAccount(name string) (Account, bool) {
+	if name == "" {
+		return Account{
+			Email:           c.Email,
+			Password:        c.Password,
+			Authorization:   c.Authorization,
+			CredentialsFile: c.credentialsPath(),
+		}, true
+	}
+	acc, ok := c.Accounts[name]
+	if !ok {
+		return Account{}, false
+	}
+	if acc.CredentialsFile == "" {
+		acc.CredentialsFile = filepath.Join(filepath.Dir(configFile), "credentials-"+name+".json")
+	}
+	return acc, true
 }
 
 func SaveConfig(cfg *Config) error {
@@ -85,7 +251,9 @@ func SaveConfig(cfg *Config) error {
 	defer func(encoder *yaml.Encoder) {
 		_ = encoder.Close()
 	}(encoder)
-	err = encoder.Encode(cfg)
+	// secrets from the environment must not end up on disk
+	out := withoutEnvSecrets(cfg)
+	err = encoder.Encode(&out)
 	if err != nil {
 		return errors.New("failed to encode config file: " + configFile + ". Error: " + err.Error())
 	}
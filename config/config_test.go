@@ -0,0 +1,48 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupAccountUsesOwnCredentialsFile(t *testing.T) {
+	saved := Cfg
+	defer func() { Cfg = saved }()
+	Cfg.CredentialsFile = "global.json"
+
+	c := Config{CredentialsFile: "reloaded.json"}
+	acc, ok := c.LookupAccount("")
+	if !ok || acc.CredentialsFile != "reloaded.json" {
+		t.Errorf("LookupAccount(\"\") = %+v, %v, want credentials file reloaded.json", acc, ok)
+	}
+}
+
+func TestDecodeErrorUnknownKeysOnly(t *testing.T) {
+	tests := []struct {
+		yaml        string
+		unknownOnly bool
+	}{
+		{"threadNum: 2\nthredNum: 3\n", true},
+		{"threadNum: many\nthredNum: 3\n", false},
+		{"threadNum: [\n", false},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(file, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := Defaults()
+		var decodeErr *DecodeError
+		if err := decodeFile(file, &cfg); !errors.As(err, &decodeErr) {
+			t.Fatalf("%q: err = %v, want a DecodeError", tt.yaml, err)
+		}
+		if decodeErr.UnknownKeysOnly() != tt.unknownOnly {
+			t.Errorf("%q: UnknownKeysOnly() = %v, want %v (%v)", tt.yaml, !tt.unknownOnly, tt.unknownOnly, decodeErr)
+		}
+		if tt.unknownOnly && cfg.ThreadNum != 2 {
+			t.Errorf("%q: threadNum = %d, want the known key applied", tt.yaml, cfg.ThreadNum)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable read by iwaradl.
+const EnvPrefix = "IWARADL_"
+
+// secretKeys accept a _FILE variant and are never saved back to disk when
+// they come from the environment. proxyUrl may carry proxy credentials,
+// hooks may hold webhook URLs with tokens and accounts hold passwords; token
+// is the secret of an entry in apiTokens.
+var secretKeys = map[string]bool{
+	"email":         true,
+	"password":      true,
+	"authorization": true,
+	"proxyUrl":      true,
+	"apiToken":      true,
+	"apiTokens":     true,
+	"token":         true,
+	"hooks":         true,
+	"accounts":      true,
+}
+
+// envSecrets records the secrets set from the environment by the last
+// ApplyEnv, keyed by config path such as "password" or "accounts.alice.password".
+var envSecrets = make(map[string]bool)
+
+// EnvName returns the environment variable for a config key,
+// e.g. rootDir becomes IWARADL_ROOT_DIR.
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, r := range key {
+		switch {
+		case unicode.IsUpper(r) && i > 0:
+			b.WriteByte('_')
+			b.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// ApplyEnv overrides cfg with IWARADL_* environment variables. Lists and maps
+// (hooks, accounts, apiTokens) take YAML or JSON. Secrets can be read from the
+// file named by the variable with a _FILE suffix. Each configured account also
+// reads IWARADL_ACCOUNTS_<NAME>_EMAIL, _PASSWORD, _AUTHORIZATION and
+// _CREDENTIALS_FILE, and each API token IWARADL_API_TOKENS_<NAME>_TOKEN,
+// _SCOPES and so on.
+func ApplyEnv(cfg *Config) error {
+	envSecrets = make(map[string]bool)
+	if err := applyEnvFields(reflect.ValueOf(cfg).Elem(), "", EnvPrefix); err != nil {
+		return err
+	}
+	for name, acc := range cfg.Accounts {
+		prefix := EnvPrefix + "ACCOUNTS_" + envSegment(name) + "_"
+		if err := applyEnvFields(reflect.ValueOf(&acc).Elem(), "accounts."+name+".", prefix); err != nil {
+			return err
+		}
+		cfg.Accounts[name] = acc
+	}
+	for name, tok := range cfg.ApiTokens {
+		prefix := EnvPrefix + "API_TOKENS_" + envSegment(name) + "_"
+		if err := applyEnvFields(reflect.ValueOf(&tok).Elem(), "apiTokens."+name+".", prefix); err != nil {
+			return err
+		}
+		cfg.ApiTokens[name] = tok
+	}
+	return nil
+}
+
+func applyEnvFields(v reflect.Value, path string, envPrefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		secret := secretKeys[key]
+		name := envPrefix + strings.TrimPrefix(EnvName(key), EnvPrefix)
+		value, ok, err := lookupEnv(name, secret)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return errors.New("invalid " + name + ": " + err.Error())
+		}
+		if secret {
+			envSecrets[path+key] = true
+		}
+	}
+	return nil
+}
+
+// envSegment upper-cases an account or token name for use in a variable name.
+func envSegment(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// lookupEnv reads name, or for secrets the file named by name_FILE.
+func lookupEnv(name string, secret bool) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	if !secret {
+		return value, ok, nil
+	}
+	file, fileOK := os.LookupEnv(name + "_FILE")
+	if !fileOK {
+		return value, ok, nil
+	}
+	if ok {
+		return "", false, errors.New("both " + name + " and " + name + "_FILE are set")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, errors.New("failed to read " + name + "_FILE: " + err.Error())
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func setField(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	default:
+		// lists and maps are given as YAML, which includes JSON
+		p := reflect.New(f.Type())
+		if err := yaml.Unmarshal([]byte(value), p.Interface()); err != nil {
+			return err
+		}
+		f.Set(p.Elem())
+	}
+	return nil
+}
+
+func yamlKey(f reflect.StructField) string {
+	key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// withoutEnvSecrets returns a copy of cfg for saving, with secrets that came
+// from the environment replaced by the values in the config file on disk.
+func withoutEnvSecrets(cfg *Config) Config {
+	out := *cfg
+	if len(envSecrets) == 0 {
+		return out
+	}
+	var disk Config
+	_ = decodeFile(configFile, &disk)
+
+	restoreSecrets(reflect.ValueOf(&out).Elem(), reflect.ValueOf(&disk).Elem(), "")
+	if !envSecrets["accounts"] && cfg.Accounts != nil {
+		out.Accounts = make(map[string]Account, len(cfg.Accounts))
+		for name, acc := range cfg.Accounts {
+			diskAcc := disk.Accounts[name]
+			restoreSecrets(reflect.ValueOf(&acc).Elem(), reflect.ValueOf(&diskAcc).Elem(), "accounts."+name+".")
+			out.Accounts[name] = acc
+		}
+	}
+	if !envSecrets["apiTokens"] && cfg.ApiTokens != nil {
+		out.ApiTokens = make(map[string]ApiToken, len(cfg.ApiTokens))
+		for name, tok := range cfg.ApiTokens {
+			diskTok := disk.ApiTokens[name]
+			restoreSecrets(reflect.ValueOf(&tok).Elem(), reflect.ValueOf(&diskTok).Elem(), "apiTokens."+name+".")
+			out.ApiTokens[name] = tok
+		}
+	}
+	return out
+}
+
+func restoreSecrets(out reflect.Value, disk reflect.Value, path string) {
+	t := out.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if envSecrets[path+yamlKey(t.Field(i))] {
+			out.Field(i).Set(disk.Field(i))
+		}
+	}
+}
+
+// Masked returns a copy of cfg that is safe to print: secrets are replaced by
+// "***", and only the host of proxy and webhook URLs is kept.
+func Masked(cfg Config) Config {
+	cfg.Email = maskSecret(cfg.Email)
+	cfg.Password = maskSecret(cfg.Password)
+	cfg.Authorization = maskSecret(cfg.Authorization)
+	cfg.ApiToken = maskSecret(cfg.ApiToken)
+	cfg.ProxyUrl = MaskURL(cfg.ProxyUrl)
+	if cfg.Hooks != nil {
+		hooks := make([]Hook, len(cfg.Hooks))
+		for i, h := range cfg.Hooks {
+			h.Webhook = MaskURL(h.Webhook)
+			hooks[i] = h
+		}
+		cfg.Hooks = hooks
+	}
+	if cfg.Accounts != nil {
+		accounts := make(map[string]Account, len(cfg.Accounts))
+		for name, acc := range cfg.Accounts {
+			acc.Email = maskSecret(acc.Email)
+			acc.Password = maskSecret(acc.Password)
+			acc.Authorization = maskSecret(acc.Authorization)
+			accounts[name] = acc
+		}
+		cfg.Accounts = accounts
+	}
+	if cfg.ApiTokens != nil {
+		tokens := make(map[string]ApiToken, len(cfg.ApiTokens))
+		for name, tok := range cfg.ApiTokens {
+			tok.Token = maskSecret(tok.Token)
+			tokens[name] = tok
+		}
+		cfg.ApiTokens = tokens
+	}
+	return cfg
+}
+
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "***"
+}
+
+// MaskURL keeps the scheme and host of a URL and hides credentials, path and
+// query, which may carry tokens.
+func MaskURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "***"
+	}
+	masked := u.Scheme + "://"
+	if u.User != nil {
+		masked += "***@"
+	}
+	masked += u.Host
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		masked += "/***"
+	}
+	return masked
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"rootDir":       "IWARADL_ROOT_DIR",
+		"proxyUrl":      "IWARADL_PROXY_URL",
+		"authorization": "IWARADL_AUTHORIZATION",
+		"logMaxSize":    "IWARADL_LOG_MAX_SIZE",
+	}
+	for key, want := range tests {
+		if got := EnvName(key); got != want {
+			t.Fatalf("EnvName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestApplyEnvAndSave(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, []byte("password: on-disk\naccounts:\n  alice:\n    email: a@example.com\napiTokens:\n  ci:\n    scopes: [read]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "password")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IWARADL_PASSWORD_FILE", secret)
+	t.Setenv("IWARADL_THREAD_NUM", "5")
+	t.Setenv("IWARADL_ACCOUNTS_ALICE_PASSWORD", "alice-secret")
+	t.Setenv("IWARADL_API_TOKENS_CI_TOKEN", "ci-secret")
+
+	prev := configFile
+	defer func() { configFile = prev }()
+	var cfg Config
+	if err := LoadConfig(&cfg, file); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Password != "from-file" || cfg.ThreadNum != 5 || cfg.Accounts["alice"].Password != "alice-secret" || cfg.ApiTokens["ci"].Token != "ci-secret" {
+		t.Fatalf("env not applied: %+v", cfg)
+	}
+
+	out := withoutEnvSecrets(&cfg)
+	if out.Password != "on-disk" || out.Accounts["alice"].Password != "" || out.Accounts["alice"].Email != "a@example.com" ||
+		out.ApiTokens["ci"].Token != "" || len(out.ApiTokens["ci"].Scopes) != 1 {
+		t.Fatalf("env secrets would be saved: %+v", out)
+	}
+	if out.ThreadNum != 5 {
+		t.Fatalf("non-secret override dropped: %d", out.ThreadNum)
+	}
+}
+
+func TestApplyEnvBothSet(t *testing.T) {
+	t.Setenv("IWARADL_API_TOKEN", "a")
+	t.Setenv("IWARADL_API_TOKEN_FILE", "/nonexistent")
+	var cfg Config
+	if err := ApplyEnv(&cfg); err == nil {
+		t.Fatal("expected error when both variable and _FILE are set")
+	}
+}
@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
 	"iwaradl/api"
 	"iwaradl/config"
@@ -18,10 +19,19 @@ import (
 
 type ProgressReport struct {
 	VID           string
+	Host          string
 	BytesComplete int64
+	BytesResumed  int64 // size of the part file the attempt started from, counted in BytesComplete if resumed
 	BytesTotal    int64
+	Speed         float64       // bytes per second
+	Duration      time.Duration // time spent on this attempt, set when Done
 	Done          bool
 	Success       bool
+	Skipped       bool // a skip rule matched, nothing was downloaded
+	Title         string
+	Author        string
+	FilePath      string
+	Err           error
 }
 
 type DownloadOptions struct {
@@ -31,17 +41,40 @@ type DownloadOptions struct {
 	ProxyURL         string
 	Cookie           string
 	FilenameTemplate string
+	RateLimit        int64  // per-task limit in bytes per second, on top of the global limit
+	Account          string // named account from config, empty uses the current one
+	Quality          string // preferred resolution, empty prefers Source
+	DirPrefix        string // absolute directory the output must stay inside, empty allows any
 }
 
+// partSuffix marks a download that has not been verified and committed yet.
+const partSuffix = ".part"
+
 type downloadResult struct {
-	VID  string
-	Resp *grab.Response
+	VID      string
+	Host     string
+	Resp     *grab.Response
+	Info     api.VideoInfo
+	FilePath string // final path; the transfer goes to FilePath + partSuffix
+	resumed  int64  // size of the part file before the transfer started
+	Err      error  // set when the video failed before the transfer started
+	Skipped  bool   // a skip rule matched
+	Rule     string // name of the matching rule
+
+	// committed receives the outcome of the transfer once the worker has
+	// committed it; nil when nothing was transferred.
+	committed chan error
 }
 
 var (
 	progressHookMu sync.RWMutex
 	progressHook   func(ProgressReport)
 	runMu          sync.Mutex
+
+	// betweenRunsMu guards betweenRunsQueue, the changes waiting for the
+	// active run to end.
+	betweenRunsMu    sync.Mutex
+	betweenRunsQueue []func()
 )
 
 func SetProgressHook(hook func(ProgressReport)) {
@@ -59,99 +92,213 @@ func emitProgress(report ProgressReport) {
 	}
 }
 
+// ApplyBetweenRuns calls fn while no download run is active, so fn may change
+// config.Cfg without racing a run that reads it. It does not wait for a run
+// to finish: fn is queued and called when the run ends.
+func ApplyBetweenRuns(fn func()) {
+	betweenRunsMu.Lock()
+	if !runMu.TryLock() {
+		betweenRunsQueue = append(betweenRunsQueue, fn)
+		betweenRunsMu.Unlock()
+		return
+	}
+	betweenRunsMu.Unlock()
+	defer endRun()
+	fn()
+}
+
+// endRun calls the changes queued during the run and releases runMu. runMu
+// is released under betweenRunsMu, so ApplyBetweenRuns either sees the run
+// active and queues, or sees it ended and calls fn itself.
+func endRun() {
+	betweenRunsMu.Lock()
+	defer betweenRunsMu.Unlock()
+	for _, fn := range betweenRunsQueue {
+		fn()
+	}
+	betweenRunsQueue = nil
+	runMu.Unlock()
+}
+
 func ConcurrentDownload() int {
-	return ConcurrentDownloadWithOptions(DownloadOptions{})
+	return ConcurrentDownloadWithOptions(context.Background(), DownloadOptions{})
 }
 
-func ConcurrentDownloadWithOptions(opts DownloadOptions) int {
+// ConcurrentDownloadWithOptions downloads VidList with opts. Cancelling ctx
+// stops the transfers; their part files are kept for a later resume.
+func ConcurrentDownloadWithOptions(ctx context.Context, opts DownloadOptions) int {
 	runMu.Lock()
-	defer runMu.Unlock()
+	defer endRun()
 
-	origRootDir := config.Cfg.RootDir
-	origUseSubDir := config.Cfg.UseSubDir
-	origProxyURL := config.Cfg.ProxyUrl
-	origFilenameTemplate := config.Cfg.FilenameTemplate
-
-	if opts.UseSubDirSet {
-		config.Cfg.UseSubDir = opts.UseSubDir
-	}
-	if opts.ProxyURL != "" {
-		config.Cfg.ProxyUrl = opts.ProxyURL
-	}
-	if opts.FilenameTemplate != "" {
-		config.Cfg.FilenameTemplate = opts.FilenameTemplate
+	opts = opts.withDefaults()
+	account := opts.Account
+	if account == "" {
+		account = api.Default().Account()
 	}
 
-	result := api.ExecuteWithRuntimeOptions(config.Cfg.ProxyUrl, opts.Cookie, func() int {
-		return concurrentDownloadOnce(opts)
+	return api.ExecuteWithRuntimeOptions(opts.ProxyURL, opts.Cookie, account, func() int {
+		return concurrentDownloadOnce(ctx, opts)
 	})
+}
 
-	config.Cfg.RootDir = origRootDir
-	config.Cfg.UseSubDir = origUseSubDir
-	config.Cfg.ProxyUrl = origProxyURL
-	config.Cfg.FilenameTemplate = origFilenameTemplate
-
-	return result
+// withDefaults fills the options left unset from config.Cfg. The run uses
+// the result instead of overriding config.Cfg, which others read meanwhile.
+func (o DownloadOptions) withDefaults() DownloadOptions {
+	if !o.UseSubDirSet {
+		o.UseSubDir = config.Cfg.UseSubDir
+		o.UseSubDirSet = true
+	}
+	if o.ProxyURL == "" {
+		o.ProxyURL = config.Cfg.ProxyUrl
+	}
+	if o.FilenameTemplate == "" {
+		o.FilenameTemplate = config.Cfg.FilenameTemplate
+	}
+	return o
 }
 
-func DoChanVid(c *grab.Client, vidch <-chan string, respch chan<- downloadResult, opts DownloadOptions) {
+func DoChanVid(ctx context.Context, c *grab.Client, vidch <-chan string, respch chan<- downloadResult, opts DownloadOptions) {
 	for vidHost := range vidch {
 		vid, host := VidAndHost(vidHost)
-		util.DebugLog("Processing video ID: %s", vid)
+		log := util.Log.With("vid", vid, "host", host)
+		log.Debug("Processing video")
 		emptyReq, _ := grab.NewRequest(vid, "")
+		if err := ctx.Err(); err != nil {
+			resp := c.Do(emptyReq)
+			respch <- downloadResult{VID: vid, Host: host, Resp: resp, Err: err}
+			continue
+		}
 		vi, err := api.GetVideoInfo(vid, host)
 		if err != nil {
-			println(vid + ": " + err.Error())
+			log.Error("Failed to get video info", "err", err)
 			resp := c.Do(emptyReq)
-			respch <- downloadResult{VID: vid, Resp: resp}
+			respch <- downloadResult{VID: vid, Host: host, Resp: resp, Err: err}
 			continue
 		}
-		u, quality := api.GetVideoUrl(vi, host)
-		if u == "" {
-			util.DebugLog("Failed to get video URL for ID: %s", vid)
-			println("Get video url " + vid + " failed")
+		vopts, rule, skip := applyRule(opts, vi)
+		if skip {
+			log.Info("Skipping video by rule", "rule", rule.Name)
 			resp := c.Do(emptyReq)
-			respch <- downloadResult{VID: vid, Resp: resp}
+			respch <- downloadResult{VID: vid, Host: host, Resp: resp, Info: vi, Skipped: true, Rule: rule.Name}
 			continue
 		}
-		out, err := ResolveOutputPath(vi, quality, opts.RootDir, opts.FilenameTemplate)
-		if err != nil {
-			println(vid + ": " + err.Error())
+		u, quality := api.GetVideoUrlQuality(vi, host, vopts.Quality)
+		if u == "" {
+			log.Error("Failed to get video url")
 			resp := c.Do(emptyReq)
-			respch <- downloadResult{VID: vid, Resp: resp}
+			respch <- downloadResult{VID: vid, Host: host, Resp: resp, Info: vi, Err: errNoSource}
 			continue
 		}
-		// generate nfo filename
-		nfoFile := strings.TrimSuffix(out.FilePath, ".mp4") + ".nfo"
-		_, _, err = WriteNfoToPath(vi, nfoFile)
+		out, err := ResolveOutputPath(vi, quality, vopts)
+		if err == nil && opts.DirPrefix != "" && !WithinDir(out.FilePath, opts.DirPrefix) {
+			err = fmt.Errorf("output path %s is outside %s", out.FilePath, opts.DirPrefix)
+		}
+		if err == nil {
+			err = os.MkdirAll(out.Dir, 0755)
+		}
 		if err != nil {
-			println(vid + ": " + err.Error())
+			log.Error("Failed to resolve output path", "err", err)
 			resp := c.Do(emptyReq)
-			respch <- downloadResult{VID: vid, Resp: resp}
+			respch <- downloadResult{VID: vid, Host: host, Resp: resp, Info: vi, Err: err}
 			continue
 		}
-		filename := out.FilePath
-		util.DebugLog("Starting download: %s", filename)
+		filename := out.FilePath + partSuffix
+		log.Debug("Starting download", "file", filename)
 		req, err := grab.NewRequest(filename, u)
 		if err != nil {
-			println(vid + ": " + err.Error())
+			log.Error("Failed to create download request", "err", err)
 			resp := c.Do(emptyReq)
-			respch <- downloadResult{VID: vid, Resp: resp}
+			respch <- downloadResult{VID: vid, Host: host, Resp: resp, Info: vi, FilePath: out.FilePath, Err: err}
 			continue
 		}
+		req = req.WithContext(ctx)
+		req.RateLimiter = requestRateLimiter(opts.RateLimit)
+		var resumed int64
+		if fi, err := os.Stat(filename); err == nil {
+			resumed = fi.Size()
+		}
 		resp := c.Do(req)
-		respch <- downloadResult{VID: vid, Resp: resp}
+		committed := make(chan error, 1)
+		respch <- downloadResult{VID: vid, Host: host, Resp: resp, Info: vi, FilePath: out.FilePath, resumed: resumed, committed: committed}
 		<-resp.Done
+		if err := resp.Err(); err != nil {
+			committed <- err
+		} else {
+			committed <- commitDownload(resp, vi, out.FilePath)
+		}
+	}
+}
+
+// commitDownload verifies a finished part file and writes the nfo next to it,
+// then moves both to their final names, so the library never holds a partial
+// item. It runs on the download worker as the nfo needs another request.
+func commitDownload(resp *grab.Response, vi api.VideoInfo, filePath string) error {
+	partFile := resp.Filename
+	fi, err := os.Stat(partFile)
+	if err != nil {
+		return err
+	}
+	if size := resp.Size(); size > 0 && fi.Size() != size {
+		return fmt.Errorf("%w: got %d bytes, want %d", errSizeMismatch, fi.Size(), size)
+	}
+
+	nfoFile := strings.TrimSuffix(filePath, ".mp4") + ".nfo"
+	nfoPart := nfoFile + partSuffix
+	if _, _, err := writeNfo(vi, nfoPart); err != nil {
+		_ = os.Remove(nfoPart)
+		return err
+	}
+	if err := os.Rename(partFile, filePath); err != nil {
+		_ = os.Remove(nfoPart)
+		return err
+	}
+	if err := os.Rename(nfoPart, nfoFile); err != nil {
+		// take the video back out of the library
+		_ = os.Rename(filePath, partFile)
+		_ = os.Remove(nfoPart)
+		return err
+	}
+	util.Log.Debug("Committed download", "vid", vi.Id, "file", filePath)
+	return nil
+}
+
+// writeNfo is WriteNfoToPath, replaceable in tests.
+var writeNfo = WriteNfoToPath
+
+// finished reports whether item is done and how it ended. A transfer is only
+// done once its worker has committed it.
+func finished(item downloadResult) (bool, error) {
+	if item.committed == nil {
+		if !item.Resp.IsComplete() {
+			return false, nil
+		}
+		if item.Err != nil {
+			return true, item.Err
+		}
+		return true, item.Resp.Err()
+	}
+	select {
+	case err := <-item.committed:
+		return true, err
+	default:
+		return false, nil
 	}
 }
 
-func concurrentDownloadOnce(opts DownloadOptions) int {
+func displayName(item downloadResult) string {
+	if item.FilePath != "" {
+		return item.FilePath
+	}
+	return item.Resp.Filename
+}
+
+func concurrentDownloadOnce(ctx context.Context, opts DownloadOptions) int {
 	util.DebugLog("Starting concurrent download process")
 	newList := make([]string, 0)
 	newList = append(newList, VidList...)
 	for i := 0; i < len(VidList); i++ {
 		if FindHistory(VidList[i]) {
-			println("Video " + VidList[i] + " already downloaded")
+			util.Log.Info("Video already downloaded", "vid", VidList[i])
 			newList = RemoveVid(newList, VidList[i])
 		}
 	}
@@ -164,8 +311,8 @@ func concurrentDownloadOnce(opts DownloadOptions) int {
 	util.DebugLog("Initializing download client with %d threads", config.Cfg.ThreadNum)
 	client := grab.NewClient()
 	tr := &http.Transport{Proxy: http.ProxyFromEnvironment}
-	if config.Cfg.ProxyUrl != "" {
-		parsedURL, err := url.Parse(config.Cfg.ProxyUrl)
+	if opts.ProxyURL != "" {
+		parsedURL, err := url.Parse(opts.ProxyURL)
 		if err == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https" || parsedURL.Scheme == "socks5") {
 			tr.Proxy = http.ProxyURL(parsedURL)
 		}
@@ -176,7 +323,7 @@ func concurrentDownloadOnce(opts DownloadOptions) int {
 	for i := 0; i < config.Cfg.ThreadNum; i++ {
 		wg.Add(1)
 		go func() {
-			DoChanVid(client, vidch, respch, opts)
+			DoChanVid(ctx, client, vidch, respch, opts)
 			wg.Done()
 		}()
 	}
@@ -192,12 +339,12 @@ func concurrentDownloadOnce(opts DownloadOptions) int {
 
 	t := time.NewTicker(500 * time.Millisecond)
 	defer t.Stop()
-	fmt.Print("\033[s")
+	printer := newProgressPrinter()
 
 	completed := 0
 	succeeded := 0
-	inProgress := 0
 	responses := make([]downloadResult, 0)
+	skipped := make(map[string]bool)
 
 	for completed < len(VidList) {
 		select {
@@ -206,42 +353,54 @@ func concurrentDownloadOnce(opts DownloadOptions) int {
 				responses = append(responses, item)
 			}
 		case <-t.C:
-			if inProgress > 0 {
-				fmt.Printf("\033[%dA\033[K", inProgress)
-			}
-			inProgress = 0
+			printer.beginTick()
 			for i, item := range responses {
 				resp := item.Resp
-				if resp != nil && resp.IsComplete() {
-					if resp.Err() == nil {
-						fmt.Printf("%s\n", util.FormatCompletionMessage(resp.Filename))
-						util.DebugLog("Download completed successfully: %s", item.VID)
+				if resp == nil {
+					continue
+				}
+				if item.Skipped {
+					if resp.IsComplete() {
+						printer.skipped(item)
+						util.Log.Info("Video skipped", "vid", item.VID, "host", item.Host, "rule", item.Rule)
+						skipped[item.VID] = true
+						emitProgress(ProgressReport{VID: item.VID, Done: true, Success: true, Skipped: true,
+							Host: item.Host, Title: item.Info.Title, Author: item.Info.User.Username})
+						responses[i].Resp = nil
+						completed++
+						succeeded++
+					}
+				} else if done, err := finished(item); done {
+					if err == nil {
+						printer.completed(item)
+						util.Log.Info("Download completed", "vid", item.VID, "host", item.Host, "file", item.FilePath)
 						SaveHistory(item.VID)
-						emitProgress(ProgressReport{VID: item.VID, BytesComplete: resp.Size(), BytesTotal: resp.Size(), Done: true, Success: true})
+						emitProgress(ProgressReport{VID: item.VID, BytesComplete: resp.Size(), BytesResumed: item.resumed, BytesTotal: resp.Size(), Duration: resp.Duration(), Done: true, Success: true,
+							Host: item.Host, Title: item.Info.Title, Author: item.Info.User.Username, FilePath: item.FilePath})
 						succeeded++
 					} else {
-						filename := filepath.Base(resp.Filename)
 						if resp.Request != nil && resp.Request.HTTPRequest != nil && resp.Request.HTTPRequest.Host != "" {
-							util.DebugLog("Download failed: %s, error: %v", filename, resp.Err())
-							_, _ = fmt.Fprintf(os.Stderr, "Download %v failed: %v\n", filename, resp.Err())
+							util.Log.Error("Download failed", "vid", item.VID, "host", item.Host, "file", filepath.Base(displayName(item)), "err", err)
 						}
-						emitProgress(ProgressReport{VID: item.VID, BytesComplete: resp.BytesComplete(), BytesTotal: resp.Size(), Done: true, Success: false})
+						printer.failed(item, err)
+						emitProgress(ProgressReport{VID: item.VID, BytesComplete: resp.BytesComplete(), BytesResumed: item.resumed, BytesTotal: resp.Size(), Duration: resp.Duration(), Done: true, Success: false,
+							Host: item.Host, Title: item.Info.Title, Author: item.Info.User.Username, FilePath: item.FilePath, Err: err})
 					}
 					responses[i].Resp = nil
 					completed++
 				}
 			}
 
+			running := make([]downloadResult, 0, len(responses))
 			for _, item := range responses {
 				resp := item.Resp
-				if resp != nil && !resp.IsComplete() {
-					inProgress++
-					filename := filepath.Base(resp.Filename)
-					emitProgress(ProgressReport{VID: item.VID, BytesComplete: resp.BytesComplete(), BytesTotal: resp.Size(), Done: false, Success: false})
-					statusLine := util.FormatDownloadStatus(filename, resp.BytesComplete(), resp.Size(), resp.Progress())
-					fmt.Printf("%s\033[K\n", statusLine)
+				if resp != nil {
+					emitProgress(ProgressReport{VID: item.VID, BytesComplete: resp.BytesComplete(), BytesResumed: item.resumed, BytesTotal: resp.Size(), Speed: resp.BytesPerSecond(), Done: false, Success: false,
+						Host: item.Host, Title: item.Info.Title, Author: item.Info.User.Username, FilePath: item.FilePath})
+					running = append(running, item)
 				}
 			}
+			printer.running(running)
 		}
 	}
 
@@ -250,8 +409,15 @@ func concurrentDownloadOnce(opts DownloadOptions) int {
 			VidList = RemoveVid(VidList, VidList[i])
 		}
 	}
+	remaining := make([]string, 0, len(VidList))
+	for _, v := range VidList {
+		if vid, _ := VidAndHost(v); !skipped[vid] {
+			remaining = append(remaining, v)
+		}
+	}
+	VidList = remaining
 	SaveVidList()
 
-	fmt.Printf("%d files completed, %d successed and %d failed.\n", completed, succeeded, completed-succeeded)
+	printer.summary(completed, succeeded)
 	return completed - succeeded
 }
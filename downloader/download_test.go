@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"errors"
+	"iwaradl/api"
+	"iwaradl/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cavaliergopher/grab/v3"
+)
+
+func TestCommitDownload(t *testing.T) {
+	defer func(w func(api.VideoInfo, string) (string, string, error)) { writeNfo = w }(writeNfo)
+
+	dir := t.TempDir()
+	video := filepath.Join(dir, "v.mp4")
+	nfo := filepath.Join(dir, "v.nfo")
+	part := video + partSuffix
+	if err := os.WriteFile(part, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp := &grab.Response{Filename: part}
+
+	// a failed nfo keeps the video out of the library
+	writeNfo = func(vi api.VideoInfo, path string) (string, string, error) {
+		_ = os.WriteFile(path, []byte("<half"), 0644)
+		return "", "", errors.New("detail request failed")
+	}
+	if err := commitDownload(resp, api.VideoInfo{Id: "v"}, video); err == nil {
+		t.Fatal("commit succeeded without an nfo")
+	}
+	for _, f := range []string{video, nfo, nfo + partSuffix} {
+		if _, err := os.Stat(f); err == nil {
+			t.Errorf("%s exists after a failed commit", filepath.Base(f))
+		}
+	}
+	if _, err := os.Stat(part); err != nil {
+		t.Errorf("part file lost after a failed commit: %v", err)
+	}
+
+	writeNfo = func(vi api.VideoInfo, path string) (string, string, error) {
+		return vi.Title, path, os.WriteFile(path, []byte("<movie/>"), 0644)
+	}
+	if err := commitDownload(resp, api.VideoInfo{Id: "v"}, video); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{video, nfo} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s missing after commit: %v", filepath.Base(f), err)
+		}
+	}
+	for _, f := range []string{part, nfo + partSuffix} {
+		if _, err := os.Stat(f); err == nil {
+			t.Errorf("%s left behind after commit", filepath.Base(f))
+		}
+	}
+}
+
+func TestApplyBetweenRunsQueuesDuringRun(t *testing.T) {
+	runMu.Lock() // a run is active
+	var applied []int
+	queued := make(chan struct{})
+	go func() {
+		ApplyBetweenRuns(func() { applied = append(applied, 1) })
+		ApplyBetweenRuns(func() { applied = append(applied, 2) })
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("ApplyBetweenRuns waited for the run")
+	}
+	if len(applied) != 0 {
+		t.Fatalf("applied %v during the run", applied)
+	}
+
+	endRun()
+	ApplyBetweenRuns(func() { applied = append(applied, 3) })
+	if !slices.Equal(applied, []int{1, 2, 3}) {
+		t.Errorf("applied %v, want [1 2 3]", applied)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	defer func(c config.Config) { config.Cfg = c }(config.Cfg)
+	config.Cfg.ProxyUrl = "http://cfg:1"
+	config.Cfg.FilenameTemplate = "{{video_id}}"
+	config.Cfg.UseSubDir = true
+
+	got := DownloadOptions{}.withDefaults()
+	if got.ProxyURL != "http://cfg:1" || got.FilenameTemplate != "{{video_id}}" || !got.UseSubDir {
+		t.Errorf("defaults not filled from config: %+v", got)
+	}
+	got = DownloadOptions{ProxyURL: "http://task:1", FilenameTemplate: "{{title}}", UseSubDirSet: true}.withDefaults()
+	if got.ProxyURL != "http://task:1" || got.FilenameTemplate != "{{title}}" || got.UseSubDir {
+		t.Errorf("task options overridden: %+v", got)
+	}
+	if config.Cfg.ProxyUrl != "http://cfg:1" || config.Cfg.FilenameTemplate != "{{video_id}}" {
+		t.Error("withDefaults changed config.Cfg")
+	}
+}
@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"iwaradl/api"
+	"net"
+	"os"
+
+	"github.com/cavaliergopher/grab/v3"
+)
+
+// Error classes group download failures for reports and task filters.
+const (
+	ErrClassUnauthorized = "unauthorized"
+	ErrClassForbidden    = "forbidden"
+	ErrClassNotFound     = "not_found"
+	ErrClassRateLimited  = "rate_limited"
+	ErrClassCloudflare   = "cloudflare"
+	ErrClassServer       = "server"
+	ErrClassNetwork      = "network"
+	ErrClassFilesystem   = "filesystem"
+	ErrClassIncomplete   = "incomplete"
+	ErrClassNoSource     = "no_source"
+	ErrClassCanceled     = "canceled"
+	ErrClassUnknown      = "unknown"
+)
+
+var (
+	errNoSource     = errors.New("no downloadable source found")
+	errSizeMismatch = errors.New("size mismatch")
+)
+
+// ClassifyError maps a download error to one of the ErrClass values,
+// or "" for a nil error.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var httpErr *api.HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.CFMitigated != "" {
+			return ErrClassCloudflare
+		}
+		return classifyStatus(httpErr.StatusCode)
+	}
+	var statusErr grab.StatusCodeError
+	if errors.As(err, &statusErr) {
+		return classifyStatus(int(statusErr))
+	}
+
+	switch {
+	case errors.Is(err, errNoSource):
+		return ErrClassNoSource
+	case errors.Is(err, grab.ErrBadLength), errors.Is(err, errSizeMismatch):
+		return ErrClassIncomplete
+	case errors.Is(err, context.Canceled):
+		return ErrClassCanceled
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrClassNetwork
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) {
+		return ErrClassFilesystem
+	}
+	return ErrClassUnknown
+}
+
+func classifyStatus(code int) string {
+	switch {
+	case code == 401:
+		return ErrClassUnauthorized
+	case code == 403:
+		return ErrClassForbidden
+	case code == 404:
+		return ErrClassNotFound
+	case code == 429:
+		return ErrClassRateLimited
+	case code >= 500:
+		return ErrClassServer
+	}
+	return ErrClassUnknown
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iwaradl/api"
+	"testing"
+
+	"github.com/cavaliergopher/grab/v3"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{&api.HTTPError{StatusCode: 403}, ErrClassForbidden},
+		{&api.HTTPError{StatusCode: 403, CFMitigated: "challenge"}, ErrClassCloudflare},
+		{&api.HTTPError{StatusCode: 404}, ErrClassNotFound},
+		{&api.HTTPError{StatusCode: 429}, ErrClassRateLimited},
+		{&api.HTTPError{StatusCode: 502}, ErrClassServer},
+		{grab.StatusCodeError(401), ErrClassUnauthorized},
+		{fmt.Errorf("%w: got 1 bytes, want 2", errSizeMismatch), ErrClassIncomplete},
+		{errNoSource, ErrClassNoSource},
+		{context.Canceled, ErrClassCanceled},
+		{&fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}, ErrClassFilesystem},
+		{errors.New("boom"), ErrClassUnknown},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Fatalf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
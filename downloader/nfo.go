@@ -26,7 +26,6 @@ func WriteNfoToPath(vi api.VideoInfo, path string) (title string, outPath string
 	util.DebugLog("Writing NFO file: %s", path)
 	f, err := os.Create(path)
 	if err != nil {
-		println(err.Error())
 		return "", "", err
 	}
 	defer func(f *os.File) {
@@ -40,7 +39,6 @@ func WriteNfoToPath(vi api.VideoInfo, path string) (title string, outPath string
 	// marshal
 	b, err := xml.MarshalIndent(detailInfo, "", "  ")
 	if err != nil {
-		println(err.Error())
 		return "", "", err
 	}
 	_, err = f.Write(b)
@@ -66,8 +64,7 @@ func UpdateNfoFiles(rootDir string, delay int) {
 	})
 
 	if err != nil {
-		util.DebugLog("Error walking the path %s: %v", rootDir, err)
-		println("Error walking the path " + rootDir + ": " + err.Error())
+		util.Log.Error("Failed to walk nfo directory", "path", rootDir, "err", err)
 		return
 	}
 
@@ -88,8 +85,7 @@ func UpdateNfoFiles(rootDir string, delay int) {
 		// 1. read and parse nfo file
 		xmlFile, err := os.Open(nfoPath)
 		if err != nil {
-			util.DebugLog("Failed to open nfo file %s: %v", nfoPath, err)
-			println("Error: " + err.Error())
+			util.Log.Error("Failed to open nfo file", "vid", vid, "file", nfoPath, "err", err)
 			continue
 		}
 
@@ -99,8 +95,7 @@ func UpdateNfoFiles(rootDir string, delay int) {
 		var nfoData api.JellyfinNfo
 		err = xml.Unmarshal(xmlData, &nfoData)
 		if err != nil {
-			util.DebugLog("Failed to unmarshal nfo file %s: %v", nfoPath, err)
-			println("Error parsing " + baseName + ": " + err.Error())
+			util.Log.Error("Failed to parse nfo file", "vid", vid, "file", nfoPath, "err", err)
 			continue
 		}
 
@@ -108,13 +103,10 @@ func UpdateNfoFiles(rootDir string, delay int) {
 		// TODO: better way to get host
 		videoInfo, err := api.GetVideoInfo(vid, "www.iwara.tv")
 		if err != nil {
-			util.DebugLog("Failed to get video info for %s on iwara.tv: %v", vid, err)
-			println("Error: " + err.Error())
-			println("Trying www.iwara.ai...")
+			util.Log.Warn("Failed to get video info, trying www.iwara.ai", "vid", vid, "host", "www.iwara.tv", "err", err)
 			videoInfo, err = api.GetVideoInfo(vid, "www.iwara.ai")
 			if err != nil {
-				util.DebugLog("Failed to get video info for %s on iwara.ai: %v", vid, err)
-				println("Error: " + err.Error())
+				util.Log.Error("Failed to get video info", "vid", vid, "host", "www.iwara.ai", "err", err)
 				continue
 			}
 		}
@@ -135,15 +127,13 @@ func UpdateNfoFiles(rootDir string, delay int) {
 		// 4. write updated nfo
 		updatedXml, err := xml.MarshalIndent(nfoData, "", "  ")
 		if err != nil {
-			util.DebugLog("Failed to marshal updated nfo for %s: %v", vid, err)
-			println("Error: " + err.Error())
+			util.Log.Error("Failed to marshal updated nfo", "vid", vid, "err", err)
 			continue
 		}
 
 		err = os.WriteFile(nfoPath, []byte(xml.Header+string(updatedXml)), 0644)
 		if err != nil {
-			util.DebugLog("Failed to write updated nfo for %s: %v", vid, err)
-			println("Error: " + err.Error())
+			util.Log.Error("Failed to write updated nfo", "vid", vid, "file", nfoPath, "err", err)
 			continue
 		}
 
@@ -155,5 +145,5 @@ func UpdateNfoFiles(rootDir string, delay int) {
 			time.Sleep(time.Duration(delay) * time.Second)
 		}
 	}
-	println("NFO update process finished.")
+	fmt.Println("NFO update process finished.")
 }
@@ -34,12 +34,14 @@ type OutputPath struct {
 	FilePath string
 }
 
-func ResolveOutputPath(vi api.VideoInfo, quality string, downloadDirTemplate string, filenameTemplate string) (OutputPath, error) {
-	dir, err := resolveDownloadPath(downloadDirTemplate, vi, quality)
+// ResolveOutputPath resolves where vi is downloaded to, from the RootDir
+// (a download_dir template), UseSubDir and FilenameTemplate of opts.
+func ResolveOutputPath(vi api.VideoInfo, quality string, opts DownloadOptions) (OutputPath, error) {
+	dir, err := resolveDownloadPath(opts.RootDir, opts.UseSubDir, vi, quality)
 	if err != nil {
 		return OutputPath{}, err
 	}
-	stem := renderFilenameStem(vi, quality, filenameTemplate)
+	stem := renderFilenameStem(vi, quality, opts.FilenameTemplate)
 	filename := filepath.Join(dir, stem+".mp4")
 	absFilename, err := filepath.Abs(filename)
 	if err != nil {
@@ -48,6 +50,25 @@ func ResolveOutputPath(vi api.VideoInfo, quality string, downloadDirTemplate str
 	return OutputPath{Dir: dir, FilePath: absFilename}, nil
 }
 
+// ValidateTemplate parses a download dir or filename template, in Go or
+// IwaraDownloadTool syntax, against the supported template functions.
+func ValidateTemplate(tpl string) error {
+	_, err := variableTemplateParser().Parse(ConvertExternalTemplate(tpl))
+	return err
+}
+
+func variableTemplateParser() *template.Template {
+	return template.New("filename").Funcs(template.FuncMap{
+		"now":             func(layout ...string) string { return "" },
+		"publish_time":    func(layout ...string) string { return "" },
+		"title":           func() string { return "" },
+		"video_id":        func() string { return "" },
+		"author":          func() string { return "" },
+		"author_nickname": func() string { return "" },
+		"quality":         func() string { return "" },
+	})
+}
+
 // ConvertExternalTemplate converts third-party (IwaraDownloadTool) placeholders to Go template syntax.
 // Supported placeholders are %#NowTime#%, %#UploadTime#%, %#TITLE#%, %#ID#%, %#AUTHOR#%, %#ALIAS#%, %#QUALITY#%.
 func ConvertExternalTemplate(tpl string) string {
@@ -106,10 +127,10 @@ func externalTimeLayout(format string) string {
 	return replacer.Replace(format)
 }
 
-func resolveDownloadPath(pathTpl string, vi api.VideoInfo, quality string) (string, error) {
+func resolveDownloadPath(pathTpl string, useSubDir bool, vi api.VideoInfo, quality string) (string, error) {
 	pathTpl = ConvertExternalTemplate(pathTpl)
 	if strings.TrimSpace(pathTpl) == "" {
-		p := PrepareFolder(vi.User.Name)
+		p := PrepareFolder(vi.User.Name, useSubDir)
 		absPath, err := filepath.Abs(p)
 		if err != nil {
 			return "", err
@@ -120,7 +141,7 @@ func resolveDownloadPath(pathTpl string, vi api.VideoInfo, quality string) (stri
 		return absPath, nil
 	}
 
-	ctx := makeTemplateContext(vi, quality)
+	ctx := dirTemplateContext(makeTemplateContext(vi, quality))
 	t, err := templateWithVars("download_dir", ctx).Option("missingkey=default").Parse(pathTpl)
 	if err != nil {
 		return "", err
@@ -140,14 +161,30 @@ func resolveDownloadPath(pathTpl string, vi api.VideoInfo, quality string) (stri
 		resolved = filepath.Join(config.Cfg.RootDir, resolved)
 		resolved = filepath.Clean(resolved)
 	}
-	absPath, err := filepath.Abs(resolved)
-	if err != nil {
-		return "", err
-	}
-	if err := os.MkdirAll(absPath, 0755); err != nil {
-		return "", err
+	return filepath.Abs(resolved)
+}
+
+// dirTemplateContext makes the video's values safe to use as single path
+// elements, so an uploader's title such as "../x" cannot change which
+// directory a download_dir template resolves to.
+func dirTemplateContext(ctx templateContext) templateContext {
+	safe := func(s string) string {
+		name, _ := filenamify.Filenamify(s, filenamify.Options{Replacement: "_", MaxLength: 128})
+		return name
 	}
-	return absPath, nil
+	ctx.Title = safe(ctx.Title)
+	ctx.VideoID = safe(ctx.VideoID)
+	ctx.Author = safe(ctx.Author)
+	ctx.AuthorNickname = safe(ctx.AuthorNickname)
+	ctx.Quality = safe(ctx.Quality)
+	return ctx
+}
+
+// WithinDir reports whether path is dir or lies inside it. Both must be
+// absolute and clean.
+func WithinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 func renderFilenameStem(vi api.VideoInfo, quality string, tpl string) string {
@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"iwaradl/api"
+	"iwaradl/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveOutputPathKeepsTitleInDir(t *testing.T) {
+	defer func(c config.Config) { config.Cfg = c }(config.Cfg)
+	root := t.TempDir()
+	config.Cfg.RootDir = root
+	inbox := filepath.Join(root, "inbox")
+
+	vi := api.VideoInfo{Id: "v1", Title: "../../x"}
+	vi.User.Username = ".."
+	vi.User.Name = "../../../etc"
+	for _, tpl := range []string{"inbox/{{title}}", "inbox/{{author}}/{{author_nickname}}", "inbox/%#TITLE#%"} {
+		out, err := ResolveOutputPath(vi, "Source", DownloadOptions{RootDir: tpl, FilenameTemplate: "{{title}}"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !WithinDir(out.FilePath, inbox) || out.Dir == inbox {
+			t.Errorf("%s resolved to %s, want a subdirectory of %s", tpl, out.FilePath, inbox)
+		}
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	dir := filepath.FromSlash("/data/inbox")
+	tests := map[string]bool{
+		"/data/inbox":          true,
+		"/data/inbox/a/b.mp4":  true,
+		"/data/inbox2/b.mp4":   false,
+		"/data/b.mp4":          false,
+		"/data/inbox/../x.mp4": false,
+	}
+	for path, want := range tests {
+		if got := WithinDir(filepath.Clean(filepath.FromSlash(path)), dir); got != want {
+			t.Errorf("WithinDir(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iwaradl/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	ProgressAuto  = "auto"
+	ProgressTTY   = "tty"
+	ProgressPlain = "plain"
+	ProgressJSON  = "json"
+	ProgressNone  = "none"
+)
+
+// plainInterval is how often plain and json modes report running downloads.
+const plainInterval = 10 * time.Second
+
+var progressMode = ProgressAuto
+
+// SetProgressMode selects how download progress is written to stdout.
+// auto picks tty when stdout is a terminal and plain otherwise.
+func SetProgressMode(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "":
+		mode = ProgressAuto
+	case ProgressAuto, ProgressTTY, ProgressPlain, ProgressJSON, ProgressNone:
+	default:
+		return errors.New("invalid progress mode: " + mode + ", allowed values: auto, tty, plain, json, none")
+	}
+	progressMode = mode
+	return nil
+}
+
+func resolveProgressMode() string {
+	if progressMode != ProgressAuto {
+		return progressMode
+	}
+	if util.IsTerminal() {
+		return ProgressTTY
+	}
+	return ProgressPlain
+}
+
+// progressEvent is one line of --progress=json output.
+type progressEvent struct {
+	Event         string    `json:"event"`
+	VID           string    `json:"vid,omitempty"`
+	File          string    `json:"file,omitempty"`
+	BytesComplete int64     `json:"bytes_complete,omitempty"`
+	BytesTotal    int64     `json:"bytes_total,omitempty"`
+	Progress      float64   `json:"progress,omitempty"`
+	Speed         float64   `json:"speed,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+type summaryEvent struct {
+	Event     string    `json:"event"`
+	Completed int       `json:"completed"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	Time      time.Time `json:"time"`
+}
+
+// progressPrinter renders the download loop in one of the progress modes.
+type progressPrinter struct {
+	mode      string
+	out       io.Writer
+	ttyLines  int
+	lastPrint time.Time
+}
+
+func newProgressPrinter() *progressPrinter {
+	p := &progressPrinter{mode: resolveProgressMode(), out: os.Stdout}
+	if p.mode == ProgressTTY {
+		_, _ = fmt.Fprint(p.out, "\033[s")
+	}
+	return p
+}
+
+// beginTick erases the status lines drawn on the previous tick.
+func (p *progressPrinter) beginTick() {
+	if p.mode == ProgressTTY && p.ttyLines > 0 {
+		_, _ = fmt.Fprintf(p.out, "\033[%dA\033[K", p.ttyLines)
+	}
+	p.ttyLines = 0
+}
+
+func (p *progressPrinter) completed(item downloadResult) {
+	switch p.mode {
+	case ProgressTTY:
+		_, _ = fmt.Fprintf(p.out, "%s\n", util.FormatCompletionMessage(item.FilePath))
+	case ProgressPlain:
+		_, _ = fmt.Fprintf(p.out, "Download saved to %s\n", item.FilePath)
+	case ProgressJSON:
+		p.emit(progressEvent{Event: "completed", VID: item.VID, File: item.FilePath,
+			BytesComplete: item.Resp.Size(), BytesTotal: item.Resp.Size(), Progress: 1})
+	}
+}
+
+func (p *progressPrinter) failed(item downloadResult, err error) {
+	if p.mode == ProgressJSON {
+		p.emit(progressEvent{Event: "failed", VID: item.VID, File: item.FilePath,
+			BytesComplete: item.Resp.BytesComplete(), BytesTotal: item.Resp.Size(), Error: err.Error()})
+	}
+}
+
+func (p *progressPrinter) skipped(item downloadResult) {
+	switch p.mode {
+	case ProgressTTY, ProgressPlain:
+		_, _ = fmt.Fprintf(p.out, "Skipped %s by rule %s\n", item.VID, item.Rule)
+	case ProgressJSON:
+		p.emit(progressEvent{Event: "skipped", VID: item.VID})
+	}
+}
+
+// running reports the downloads still in flight. tty redraws every tick,
+// plain and json print at most once per plainInterval.
+func (p *progressPrinter) running(items []downloadResult) {
+	if len(items) == 0 {
+		return
+	}
+	switch p.mode {
+	case ProgressTTY:
+		for _, item := range items {
+			resp := item.Resp
+			filename := filepath.Base(displayName(item))
+			statusLine := util.FormatDownloadStatus(filename, resp.BytesComplete(), resp.Size(), resp.Progress())
+			_, _ = fmt.Fprintf(p.out, "%s\033[K\n", statusLine)
+		}
+		p.ttyLines = len(items)
+	case ProgressPlain, ProgressJSON:
+		if time.Since(p.lastPrint) < plainInterval {
+			return
+		}
+		p.lastPrint = time.Now()
+		for _, item := range items {
+			resp := item.Resp
+			if p.mode == ProgressJSON {
+				p.emit(progressEvent{Event: "progress", VID: item.VID, File: item.FilePath,
+					BytesComplete: resp.BytesComplete(), BytesTotal: resp.Size(),
+					Progress: resp.Progress(), Speed: resp.BytesPerSecond()})
+				continue
+			}
+			_, _ = fmt.Fprintf(p.out, "[%6.2f%%] %s/%s %s/s %s\n",
+				resp.Progress()*100,
+				humanize.Bytes(uint64(resp.BytesComplete())),
+				humanize.Bytes(uint64(resp.Size())),
+				humanize.Bytes(uint64(resp.BytesPerSecond())),
+				filepath.Base(displayName(item)))
+		}
+	}
+}
+
+func (p *progressPrinter) summary(completed, succeeded int) {
+	switch p.mode {
+	case ProgressJSON:
+		p.emit(summaryEvent{Event: "summary", Completed: completed, Succeeded: succeeded, Failed: completed - succeeded, Time: time.Now()})
+	case ProgressNone:
+	default:
+		_, _ = fmt.Fprintf(p.out, "%d files completed, %d successed and %d failed.\n", completed, succeeded, completed-succeeded)
+	}
+}
+
+func (p *progressPrinter) emit(ev any) {
+	if pe, ok := ev.(progressEvent); ok {
+		pe.Time = time.Now()
+		ev = pe
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	_, _ = p.out.Write(append(b, '\n'))
+}
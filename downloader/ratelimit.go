@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cavaliergopher/grab/v3"
+	"github.com/dustin/go-humanize"
+)
+
+// RateLimiter is a token bucket satisfying grab.RateLimiter. A rate of zero
+// or less disables limiting. The bucket holds at most one second of tokens.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+var globalLimiter = NewRateLimiter(0)
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// SetRate changes the limit in bytes per second, taking effect for waits that
+// start after the call.
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = float64(rate)
+	l.last = time.Now()
+}
+
+// Rate returns the current limit in bytes per second.
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// WaitN reserves n bytes from the bucket and blocks until they are available.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// multiLimiter waits on every limiter in turn, so a transfer is bound by the
+// tightest of them.
+type multiLimiter []grab.RateLimiter
+
+func (m multiLimiter) WaitN(ctx context.Context, n int) error {
+	for _, l := range m {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetGlobalRateLimit sets the bandwidth shared by all downloads in bytes per second.
+func SetGlobalRateLimit(rate int64) {
+	globalLimiter.SetRate(rate)
+}
+
+// GlobalRateLimit returns the shared bandwidth limit in bytes per second.
+func GlobalRateLimit() int64 {
+	return globalLimiter.Rate()
+}
+
+// ParseRateLimit parses a rate such as "5M", "512K" or "1.5MB/s" into bytes
+// per second. An empty string or "0" means unlimited.
+func ParseRateLimit(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/s"), "ps")
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	n, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, errors.New("invalid rate limit: " + s)
+	}
+	return int64(n), nil
+}
+
+// FormatRateLimit formats bytes per second for display, "unlimited" for zero.
+func FormatRateLimit(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return humanize.Bytes(uint64(rate)) + "/s"
+}
+
+func requestRateLimiter(taskRate int64) grab.RateLimiter {
+	if taskRate <= 0 {
+		return globalLimiter
+	}
+	return multiLimiter{NewRateLimiter(taskRate), globalLimiter}
+}
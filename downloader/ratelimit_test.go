@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{" 0 ", 0, false},
+		{"5M", 5_000_000, false},
+		{"512K", 512_000, false},
+		{"512k", 512_000, false},
+		{"1.5MB/s", 1_500_000, false},
+		{"2MBps", 2_000_000, false},
+		{"1MiB", 1 << 20, false},
+		{"100", 100, false},
+		{"fast", 0, true},
+		{"5 parsecs", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatRateLimit(t *testing.T) {
+	tests := map[int64]string{
+		0:         "unlimited",
+		-1:        "unlimited",
+		1_500_000: "1.5 MB/s",
+		512_000:   "512 kB/s",
+	}
+	for in, want := range tests {
+		if got := FormatRateLimit(in); got != want {
+			t.Errorf("FormatRateLimit(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// waitTime returns how long WaitN(n) blocks on l.
+func waitTime(t *testing.T, l interface {
+	WaitN(context.Context, int) error
+}, n int) time.Duration {
+	t.Helper()
+	start := time.Now()
+	if err := l.WaitN(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+func TestRateLimiterWaitN(t *testing.T) {
+	l := NewRateLimiter(1_000_000)
+	// the bucket starts full with one second of tokens
+	if d := waitTime(t, l, 1_000_000); d > 50*time.Millisecond {
+		t.Errorf("first second of tokens took %v, want no wait", d)
+	}
+	if d := waitTime(t, l, 200_000); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("200 kB at 1 MB/s took %v, want about 200ms", d)
+	}
+
+	l.SetRate(0)
+	if d := waitTime(t, l, 1<<30); d > 50*time.Millisecond {
+		t.Errorf("unlimited wait took %v", d)
+	}
+
+	l.SetRate(1000)
+	_ = l.WaitN(context.Background(), 1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 10_000); err == nil {
+		t.Error("WaitN ignored the cancelled context")
+	}
+}
+
+func TestRequestRateLimiter(t *testing.T) {
+	defer SetGlobalRateLimit(0)
+
+	// the tighter per-task limit applies under a looser global one
+	SetGlobalRateLimit(10_000_000)
+	l := requestRateLimiter(1_000_000)
+	_ = l.WaitN(context.Background(), 1_000_000)
+	if d := waitTime(t, l, 200_000); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("task limit: 200 kB took %v, want about 200ms", d)
+	}
+
+	// and the tighter global limit under a looser per-task one
+	SetGlobalRateLimit(1_000_000)
+	l = requestRateLimiter(10_000_000)
+	_ = l.WaitN(context.Background(), 1_000_000)
+	if d := waitTime(t, l, 200_000); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("global limit: 200 kB took %v, want about 200ms", d)
+	}
+
+	if requestRateLimiter(0) != globalLimiter {
+		t.Error("a task without a limit should only use the global limiter")
+	}
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ReportEntry is the final outcome of one video in a run report.
+type ReportEntry struct {
+	VID        string  `json:"vid"`
+	Host       string  `json:"host,omitempty"`
+	Title      string  `json:"title"`
+	Author     string  `json:"author"`
+	OutputPath string  `json:"output_path,omitempty"`
+	Bytes      int64   `json:"bytes"`
+	Duration   float64 `json:"duration_seconds"`
+	Status     string  `json:"status"` // completed / failed / skipped
+	Attempts   int     `json:"attempts"`
+	Error      string  `json:"error,omitempty"`
+	ErrorClass string  `json:"error_class,omitempty"`
+}
+
+// RunReport summarizes one CLI run or daemon batch.
+type RunReport struct {
+	Name       string        `json:"name"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Total      int           `json:"total"`
+	Succeeded  int           `json:"succeeded"`
+	Failed     int           `json:"failed"`
+	Skipped    int           `json:"skipped"`
+	Videos     []ReportEntry `json:"videos"`
+}
+
+// Reporter collects finished download reports across retries.
+type Reporter struct {
+	mu      sync.Mutex
+	report  RunReport
+	entries map[string]*ReportEntry
+	order   []string
+}
+
+func NewReporter(name string) *Reporter {
+	return &Reporter{
+		report:  RunReport{Name: name, StartedAt: time.Now()},
+		entries: make(map[string]*ReportEntry),
+	}
+}
+
+// Observe records a finished attempt. Progress updates are ignored.
+func (r *Reporter) Observe(p ProgressReport) {
+	if !p.Done || p.VID == "" {
+		return
+	}
+	vid, host := VidAndHost(p.VID)
+	if p.Host != "" {
+		host = p.Host
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[vid]
+	if !ok {
+		e = &ReportEntry{VID: vid}
+		r.entries[vid] = e
+		r.order = append(r.order, vid)
+	}
+	e.Host = host
+	e.Attempts++
+	e.Duration += p.Duration.Seconds()
+	if p.Title != "" {
+		e.Title = p.Title
+	}
+	if p.Author != "" {
+		e.Author = p.Author
+	}
+	if p.FilePath != "" {
+		e.OutputPath = p.FilePath
+	}
+	e.Bytes = p.BytesComplete
+	if p.Skipped {
+		e.Status = "skipped"
+		e.Error = ""
+		e.ErrorClass = ""
+	} else if p.Success {
+		e.Status = "completed"
+		e.Error = ""
+		e.ErrorClass = ""
+	} else {
+		e.Status = "failed"
+		if p.Err != nil {
+			e.Error = p.Err.Error()
+		}
+		e.ErrorClass = ClassifyError(p.Err)
+	}
+}
+
+// Empty reports whether no attempt has been observed.
+func (r *Reporter) Empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.order) == 0
+}
+
+// Report returns a snapshot of the collected report.
+func (r *Reporter) Report() RunReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := r.report
+	rep.FinishedAt = time.Now()
+	rep.Videos = make([]ReportEntry, 0, len(r.order))
+	for _, vid := range r.order {
+		e := *r.entries[vid]
+		rep.Videos = append(rep.Videos, e)
+		switch e.Status {
+		case "completed":
+			rep.Succeeded++
+		case "skipped":
+			rep.Skipped++
+		default:
+			rep.Failed++
+		}
+	}
+	rep.Total = len(rep.Videos)
+	return rep
+}
+
+// Write saves the report as JSON, and Markdown if asked, under rootDir/reports.
+// It returns the path of the JSON file.
+func (r *Reporter) Write(rootDir string, markdown bool) (string, error) {
+	rep := r.Report()
+	dir := filepath.Join(rootDir, "reports")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Join(dir, rep.StartedAt.Format("20060102-150405")+"-"+rep.Name)
+
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		return "", err
+	}
+	if markdown {
+		if err := os.WriteFile(base+".md", []byte(rep.Markdown()), 0644); err != nil {
+			return "", err
+		}
+	}
+	return base + ".json", nil
+}
+
+// Markdown renders the report as a Markdown table.
+func (rep RunReport) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# iwaradl report %s\n\n", rep.Name)
+	fmt.Fprintf(&b, "- Started: %s\n", rep.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Finished: %s\n", rep.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Total: %d, succeeded: %d, failed: %d, skipped: %d\n\n", rep.Total, rep.Succeeded, rep.Failed, rep.Skipped)
+	b.WriteString("| Video | Title | Author | Status | Attempts | Size | Duration | Error | Output |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, e := range rep.Videos {
+		errText := e.ErrorClass
+		if e.Error != "" {
+			errText += ": " + e.Error
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %d | %s | %.0fs | %s | %s |\n",
+			e.VID,
+			markdownCell(e.Title),
+			markdownCell(e.Author),
+			e.Status,
+			e.Attempts,
+			humanize.Bytes(uint64(e.Bytes)),
+			e.Duration,
+			markdownCell(errText),
+			markdownCell(e.OutputPath))
+	}
+	return b.String()
+}
+
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(s)
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"iwaradl/api"
+	"iwaradl/config"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	RuleAllow = "allow"
+	RuleSkip  = "skip"
+)
+
+var (
+	titlePatternsMu sync.Mutex
+	titlePatterns   = make(map[string]*regexp.Regexp)
+)
+
+// MatchRule returns the first rule in rules that matches the video.
+func MatchRule(rules []config.Rule, vi api.VideoInfo) (config.Rule, bool) {
+	for _, r := range rules {
+		if ruleMatches(r, vi) {
+			return r, true
+		}
+	}
+	return config.Rule{}, false
+}
+
+func ruleMatches(r config.Rule, vi api.VideoInfo) bool {
+	if len(r.Authors) > 0 && !slices.ContainsFunc(r.Authors, func(a string) bool {
+		return strings.EqualFold(strings.TrimPrefix(a, "@"), vi.User.Username)
+	}) {
+		return false
+	}
+	if len(r.Tags) > 0 && !slices.ContainsFunc(vi.Tags, func(t api.Tag) bool {
+		return slices.ContainsFunc(r.Tags, func(want string) bool { return strings.EqualFold(want, t.Id) })
+	}) {
+		return false
+	}
+	if r.Rating != "" && !strings.EqualFold(r.Rating, vi.Rating) {
+		return false
+	}
+	if r.Title != "" {
+		re, err := titlePattern(r.Title)
+		if err != nil || !re.MatchString(vi.Title) {
+			return false
+		}
+	}
+	return true
+}
+
+func titlePattern(expr string) (*regexp.Regexp, error) {
+	titlePatternsMu.Lock()
+	defer titlePatternsMu.Unlock()
+	if re, ok := titlePatterns[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	titlePatterns[expr] = re
+	return re, nil
+}
+
+// ValidateRules checks the patterns, templates and actions of rules.
+func ValidateRules(rules []config.Rule) error {
+	var errs []error
+	for i, r := range rules {
+		name := fmt.Sprintf("rules[%d]", i)
+		if r.Name != "" {
+			name += " (" + r.Name + ")"
+		}
+		if r.Action != "" && r.Action != RuleAllow && r.Action != RuleSkip {
+			errs = append(errs, fmt.Errorf("%s: action must be allow or skip", name))
+		}
+		if r.Title != "" {
+			if _, err := regexp.Compile(r.Title); err != nil {
+				errs = append(errs, fmt.Errorf("%s: title: %w", name, err))
+			}
+		}
+		if r.DownloadDir != "" {
+			if err := ValidateTemplate(r.DownloadDir); err != nil {
+				errs = append(errs, fmt.Errorf("%s: downloadDir: %w", name, err))
+			}
+		}
+		if r.FilenameTemplate != "" {
+			if err := ValidateTemplate(r.FilenameTemplate); err != nil {
+				errs = append(errs, fmt.Errorf("%s: filenameTemplate: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyRule returns opts with the overrides of the first rule matching vi,
+// and whether the video should be skipped.
+func applyRule(opts DownloadOptions, vi api.VideoInfo) (DownloadOptions, config.Rule, bool) {
+	rule, ok := MatchRule(config.Cfg.Rules, vi)
+	if !ok {
+		return opts, rule, false
+	}
+	if rule.Action == RuleSkip {
+		return opts, rule, true
+	}
+	if rule.DownloadDir != "" {
+		opts.RootDir = rule.DownloadDir
+	}
+	if rule.FilenameTemplate != "" {
+		opts.FilenameTemplate = rule.FilenameTemplate
+	}
+	if rule.Quality != "" {
+		opts.Quality = rule.Quality
+	}
+	return opts, rule, false
+}
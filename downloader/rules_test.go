@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"iwaradl/api"
+	"iwaradl/config"
+	"testing"
+)
+
+func TestMatchRule(t *testing.T) {
+	rules := []config.Rule{
+		{Name: "skip-spam", Title: `(?i)\bpreview\b`, Action: RuleSkip},
+		{Name: "alice", Authors: []string{"@Alice"}, DownloadDir: "alice"},
+		{Name: "dance", Tags: []string{"dance"}, Rating: "ecchi", Quality: "540"},
+	}
+	vi := func(user, title, rating string, tags ...string) api.VideoInfo {
+		v := api.VideoInfo{Title: title, Rating: rating}
+		v.User.Username = user
+		for _, tag := range tags {
+			v.Tags = append(v.Tags, api.Tag{Id: tag})
+		}
+		return v
+	}
+	tests := []struct {
+		vi   api.VideoInfo
+		want string
+	}{
+		{vi("alice", "Preview cut", "general"), "skip-spam"},
+		{vi("alice", "Full", "general"), "alice"},
+		{vi("bob", "Full", "ecchi", "music", "Dance"), "dance"},
+		{vi("bob", "Full", "general", "dance"), ""},
+		{vi("bob", "Full", "ecchi"), ""},
+	}
+	for _, tt := range tests {
+		got, ok := MatchRule(rules, tt.vi)
+		if tt.want == "" {
+			if ok {
+				t.Fatalf("MatchRule(%s) = %q, want no match", tt.vi.Title, got.Name)
+			}
+			continue
+		}
+		if !ok || got.Name != tt.want {
+			t.Fatalf("MatchRule(%s/%s) = %q, want %q", tt.vi.User.Username, tt.vi.Title, got.Name, tt.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	if err := ValidateRules([]config.Rule{{Title: "a+", Action: RuleSkip}, {FilenameTemplate: "{{title}}"}}); err != nil {
+		t.Fatalf("ValidateRules() = %v", err)
+	}
+	if err := ValidateRules([]config.Rule{{Title: "("}, {Action: "drop"}}); err == nil {
+		t.Fatal("ValidateRules() accepted an invalid rule")
+	}
+}
@@ -46,32 +46,37 @@ func ParseUrl(u string) (vid string, user string, host string, err error) {
 }
 
 // PrepareFolder create folder for download
-func PrepareFolder(username string) string {
+func PrepareFolder(username string, useSubDir bool) string {
 	util.DebugLog("Preparing download folder for user: %s", username)
 	path := config.Cfg.RootDir
 	err := os.Mkdir(path, 0755)
 	if err != nil && !errors.Is(err, os.ErrExist) {
-		println(err.Error())
+		util.Log.Error("Failed to create download folder", "path", path, "err", err)
 	}
-	if config.Cfg.UseSubDir && username != "" {
+	if useSubDir && username != "" {
 		subfolder, _ := filenamify.Filenamify(username, filenamify.Options{Replacement: "_", MaxLength: 64})
 		path = filepath.Join(path, subfolder)
 		err = os.Mkdir(path, 0755)
 		if err != nil && !errors.Is(err, os.ErrExist) {
-			println(err.Error())
+			util.Log.Error("Failed to create download folder", "path", path, "err", err)
 		}
 	}
 	return path
 }
 
 // ProcessUrlList get vid from video url or vid list from user url
-func ProcessUrlList(urls []string) (vids []string) {
+func ProcessUrlList(urls []string) []string {
+	return ProcessUrlListWithSession(api.Current(), urls)
+}
+
+// ProcessUrlListWithSession is ProcessUrlList with user pages fetched by s.
+func ProcessUrlListWithSession(s *api.Session, urls []string) (vids []string) {
 	util.DebugLog("Processing URL list with %d URLs", len(urls))
 
 	for _, u := range urls {
 		vid, user, host, err := ParseUrl(u)
 		if err != nil {
-			println(err.Error())
+			util.Log.Warn("Skipping invalid url", "url", u, "err", err)
 			continue
 		}
 		if vid != "" {
@@ -79,7 +84,7 @@ func ProcessUrlList(urls []string) (vids []string) {
 			util.DebugLog("Added video ID to list: %s", vid)
 		} else if user != "" {
 			util.DebugLog("Fetching video list for user: %s", user)
-			videos := api.GetVideoListByUser(user, host)
+			videos := s.GetVideoListByUser(user, host)
 			for _, vi := range videos {
 				vids = append(vids, vi.Id+"@"+host)
 			}
@@ -118,7 +123,7 @@ func SaveVidList() {
 	urlFile := filepath.Join(config.Cfg.RootDir, "jobs.list")
 	file, err := os.OpenFile(urlFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		println(err.Error())
+		util.Log.Error("Failed to open job list", "file", urlFile, "err", err)
 		return
 	}
 	defer func(file *os.File) {
@@ -128,7 +133,7 @@ func SaveVidList() {
 	for _, v := range VidList {
 		_, err := file.WriteString(v + "\n")
 		if err != nil {
-			println(err.Error())
+			util.Log.Error("Failed to write job list", "file", urlFile, "err", err)
 		}
 	}
 }
@@ -171,7 +176,7 @@ func SaveHistory(vid string) {
 	historyFile := filepath.Join(config.Cfg.RootDir, "history.list")
 	file, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		println(err.Error())
+		util.Log.Error("Failed to open history", "file", historyFile, "err", err)
 		return
 	}
 	defer func(file *os.File) {
@@ -179,7 +184,7 @@ func SaveHistory(vid string) {
 	}(file)
 	_, err = file.WriteString(vid + "\n")
 	if err != nil {
-		println(err.Error())
+		util.Log.Error("Failed to write history", "file", historyFile, "vid", vid, "err", err)
 		return
 	}
 }
@@ -0,0 +1,496 @@
+// Package filter implements the small expression language used by genlist to
+// select videos, e.g.
+//
+//	likes/views > 0.05 && duration > 120 && !("futa" in tags) && author != "x"
+//
+// Expressions can only read the video they are evaluated against; there is no
+// assignment, loop or access to anything outside the listed fields and
+// functions.
+package filter
+
+import (
+	"fmt"
+	"iwaradl/api"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// value is one of float64, string, bool or []string.
+type value any
+
+type env struct {
+	vi  *api.VideoInfo
+	now time.Time
+}
+
+var fields = map[string]func(vi *api.VideoInfo) value{
+	"id":          func(vi *api.VideoInfo) value { return vi.Id },
+	"title":       func(vi *api.VideoInfo) value { return vi.Title },
+	"author":      func(vi *api.VideoInfo) value { return vi.User.Username },
+	"author_name": func(vi *api.VideoInfo) value { return vi.User.Name },
+	"rating":      func(vi *api.VideoInfo) value { return vi.Rating },
+	"likes":       func(vi *api.VideoInfo) value { return float64(vi.NumLikes) },
+	"views":       func(vi *api.VideoInfo) value { return float64(vi.NumViews) },
+	"comments":    func(vi *api.VideoInfo) value { return float64(vi.NumComments) },
+	"duration":    func(vi *api.VideoInfo) value { return float64(vi.File.Duration) },
+	"tags": func(vi *api.VideoInfo) value {
+		tags := make([]string, 0, len(vi.Tags))
+		for _, t := range vi.Tags {
+			tags = append(tags, t.Id)
+		}
+		return tags
+	},
+}
+
+type function struct {
+	args int
+	call func(e env, args []value) (value, error)
+}
+
+var functions = map[string]function{
+	// days since the video was published, fractional
+	"age_days": {0, func(e env, _ []value) (value, error) {
+		return e.now.Sub(e.vi.CreatedAt).Hours() / 24, nil
+	}},
+	// likes per view, 0 without views
+	"like_ratio": {0, func(e env, _ []value) (value, error) {
+		if e.vi.NumViews == 0 {
+			return 0.0, nil
+		}
+		return float64(e.vi.NumLikes) / float64(e.vi.NumViews), nil
+	}},
+	"lower": {1, func(_ env, args []value) (value, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("lower needs a string, got %s", typeName(args[0]))
+		}
+		return strings.ToLower(s), nil
+	}},
+	"len": {1, func(_ env, args []value) (value, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []string:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len needs a string or list, got %s", typeName(args[0]))
+	}},
+}
+
+// Expr is a parsed filter expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse parses src, rejecting unknown fields and functions.
+func Parse(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("position %d: unexpected %q", t.pos+1, t.text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match evaluates the expression against vi, which must yield a boolean.
+func (e *Expr) Match(vi api.VideoInfo) (bool, error) {
+	v, err := e.root.eval(env{vi: &vi, now: time.Now()})
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter yields %s, want bool", typeName(v))
+	}
+	return b, nil
+}
+
+// Number evaluates the expression against vi, which must yield a number.
+func (e *Expr) Number(vi api.VideoInfo) (float64, error) {
+	v, err := e.root.eval(env{vi: &vi, now: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression yields %s, want number", typeName(v))
+	}
+	return n, nil
+}
+
+type node interface {
+	eval(e env) (value, error)
+}
+
+type literal struct{ v value }
+
+type fieldRef struct{ name string }
+
+type call struct {
+	name string
+	args []node
+}
+
+type list struct{ items []node }
+
+type unary struct {
+	op string
+	x  node
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func (n literal) eval(env) (value, error) { return n.v, nil }
+
+func (n fieldRef) eval(e env) (value, error) { return fields[n.name](e.vi), nil }
+
+func (n call) eval(e env) (value, error) {
+	args := make([]value, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return functions[n.name].call(e, args)
+}
+
+func (n list) eval(e env) (value, error) {
+	items := make([]string, 0, len(n.items))
+	for _, it := range n.items {
+		v, err := it.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("list items must be strings, got %s", typeName(v))
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+func (n unary) eval(e env) (value, error) {
+	v, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		if b, ok := v.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		if f, ok := v.(float64); ok {
+			return -f, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s does not apply to %s", n.op, typeName(v))
+}
+
+func (n binary) eval(e env) (value, error) {
+	l, err := n.l.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs bool operands, got %s", n.op, typeName(l))
+		}
+		if lb == (n.op == "||") {
+			return lb, nil
+		}
+		r, err := n.r.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs bool operands, got %s", n.op, typeName(r))
+		}
+		return rb, nil
+	}
+	r, err := n.r.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "in":
+		s, ok := l.(string)
+		if !ok {
+			break
+		}
+		switch r := r.(type) {
+		case []string:
+			for _, item := range r {
+				if strings.EqualFold(item, s) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case string:
+			return strings.Contains(strings.ToLower(r), strings.ToLower(s)), nil
+		}
+	case "==", "!=":
+		eq, ok := equal(l, r)
+		if !ok {
+			break
+		}
+		return eq == (n.op == "=="), nil
+	default:
+		lf, lok := l.(float64)
+		rf, rok := r.(float64)
+		if !lok || !rok {
+			break
+		}
+		return arith(n.op, lf, rf), nil
+	}
+	return nil, fmt.Errorf("operator %s does not apply to %s and %s", n.op, typeName(l), typeName(r))
+}
+
+// equal compares two values of the same type; strings ignore case.
+func equal(l, r value) (bool, bool) {
+	switch l := l.(type) {
+	case float64:
+		r, ok := r.(float64)
+		return l == r, ok
+	case string:
+		r, ok := r.(string)
+		return strings.EqualFold(l, r), ok
+	case bool:
+		r, ok := r.(bool)
+		return l == r, ok
+	}
+	return false, false
+}
+
+// arith applies a numeric operator. Division by zero yields 0 so that e.g.
+// likes/views does not fail on videos without views.
+func arith(op string, l, r float64) value {
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		if r == 0 {
+			return 0.0
+		}
+		return l / r
+	case "%":
+		if r == 0 {
+			return 0.0
+		}
+		return math.Mod(l, r)
+	}
+	return nil
+}
+
+func typeName(v value) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []string:
+		return "list"
+	}
+	return "nothing"
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp && !(t.kind == tokIdent && t.text == "in") {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.i++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return fmt.Errorf("position %d: expected %q, got end of expression", t.pos+1, op)
+		}
+		return fmt.Errorf("position %d: expected %q, got %q", t.pos+1, op, t.text)
+	}
+	return nil
+}
+
+// binaryLevel parses operands of the next level joined by any of ops.
+func (p *parser) binaryLevel(operand func() (node, error), ops ...string) (node, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseOr() (node, error) { return p.binaryLevel(p.parseAnd, "||") }
+
+func (p *parser) parseAnd() (node, error) { return p.binaryLevel(p.parseCompare, "&&") }
+
+func (p *parser) parseCompare() (node, error) {
+	return p.binaryLevel(p.parseSum, "==", "!=", "<=", ">=", "<", ">", "in")
+}
+
+func (p *parser) parseSum() (node, error) { return p.binaryLevel(p.parseProduct, "+", "-") }
+
+func (p *parser) parseProduct() (node, error) { return p.binaryLevel(p.parseUnary, "*", "/", "%") }
+
+func (p *parser) parseUnary() (node, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("position %d: invalid number %q", t.pos+1, t.text)
+		}
+		return literal{f}, nil
+	case tokString:
+		return literal{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		if _, ok := fields[t.text]; !ok {
+			return nil, fmt.Errorf("position %d: unknown field %q", t.pos+1, t.text)
+		}
+		return fieldRef{t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var items []node
+			if _, ok := p.accept("]"); ok {
+				return list{}, nil
+			}
+			for {
+				x, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, x)
+				if _, ok := p.accept(","); !ok {
+					return list{items}, p.expect("]")
+				}
+			}
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("position %d: unexpected end of expression", t.pos+1)
+	}
+	return nil, fmt.Errorf("position %d: unexpected %q", t.pos+1, t.text)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("position %d: unknown function %q", name.pos+1, name.text)
+	}
+	var args []node
+	if _, ok := p.accept(")"); !ok {
+		for {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, x)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("position %d: %s takes %d argument(s), got %d", name.pos+1, name.text, fn.args, len(args))
+	}
+	return call{name: name.text, args: args}, nil
+}
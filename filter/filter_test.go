@@ -0,0 +1,91 @@
+package filter
+
+import (
+	"iwaradl/api"
+	"testing"
+	"time"
+)
+
+func testVideo() api.VideoInfo {
+	vi := api.VideoInfo{
+		Id:        "abc",
+		Title:     "Summer Dance",
+		Rating:    "ecchi",
+		NumLikes:  60,
+		NumViews:  1000,
+		CreatedAt: time.Now().Add(-36 * time.Hour),
+		Tags:      []api.Tag{{Id: "dance"}, {Id: "music"}},
+	}
+	vi.File.Duration = 150
+	vi.User.Username = "alice"
+	return vi
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`likes/views > 0.05 && duration > 120 && !("futa" in tags) && author != "x"`, true},
+		{`"dance" in tags && !("futa" in tags)`, true},
+		{`"DANCE" in title`, true},
+		{`author in ["bob", "Alice"]`, true},
+		{`age_days() > 1 && age_days() < 2`, true},
+		{`like_ratio() >= 0.06`, true},
+		{`likes / 0 == 0`, true},
+		{`lower(title) == "summer dance" && len(tags) == 2`, true},
+		{`likes >= 50 + 10 * 2`, false},
+		{`rating == "general" || views < 100`, false},
+		{`-likes < 0 && 7 % 4 == 3`, true},
+		{`likes % 0.5 == 0 && 7 % 0.5 == 0 && 7.5 % 2 == 1.5`, true},
+	}
+	vi := testVideo()
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+		}
+		got, err := e.Match(vi)
+		if err != nil {
+			t.Fatalf("Match(%q) error = %v", tt.expr, err)
+		}
+		if got != tt.want {
+			t.Fatalf("Match(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		`likes >`,
+		`(likes > 1`,
+		`unknown > 1`,
+		`exec("rm")`,
+		`age_days(1) > 1`,
+		`"open`,
+		`likes $ 1`,
+		`likes > 1 views`,
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	vi := testVideo()
+	for _, expr := range []string{
+		`likes`,
+		`title > 1`,
+		`likes && true`,
+		`!likes`,
+	} {
+		e, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", expr, err)
+		}
+		if _, err := e.Match(vi); err == nil {
+			t.Fatalf("Match(%q) succeeded, want error", expr)
+		}
+	}
+}
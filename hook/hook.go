@@ -0,0 +1,178 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/util"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+)
+
+const defaultWebhookRetries = 3
+
+// webhookRetryDelay is multiplied by the attempt number between webhook posts.
+var webhookRetryDelay = 2 * time.Second
+
+// Event describes a finished download passed to hook commands and webhooks.
+type Event struct {
+	Event  string    `json:"event"`
+	VID    string    `json:"vid"`
+	Title  string    `json:"title"`
+	Author string    `json:"author"`
+	File   string    `json:"file"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+var (
+	wg            sync.WaitGroup
+	webhookClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// Validate checks that every hook has a command or a webhook and only
+// subscribes to known events.
+func Validate(hooks []config.Hook) error {
+	var errs []error
+	for i, h := range hooks {
+		prefix := fmt.Sprintf("hooks[%d]: ", i)
+		if h.Command == "" && h.Webhook == "" {
+			errs = append(errs, errors.New(prefix+"needs a command or a webhook"))
+		}
+		for _, e := range h.Events {
+			if e != EventCompleted && e != EventFailed {
+				errs = append(errs, errors.New(prefix+"unknown event "+e))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FromReport builds an event from a finished download report.
+func FromReport(event string, r downloader.ProgressReport) Event {
+	vid, _ := downloader.VidAndHost(r.VID)
+	ev := Event{
+		Event:  event,
+		VID:    vid,
+		Title:  r.Title,
+		Author: r.Author,
+		File:   r.FilePath,
+	}
+	if r.Err != nil {
+		ev.Error = r.Err.Error()
+	}
+	return ev
+}
+
+// Fire runs every hook in hooks subscribed to ev.Event in the background.
+// Callers pass a copy of config.Cfg.Hooks taken under their config lock.
+func Fire(hooks []config.Hook, ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for _, h := range hooks {
+		if !subscribed(h, ev.Event) {
+			continue
+		}
+		wg.Add(1)
+		go func(h config.Hook) {
+			defer wg.Done()
+			run(h, ev)
+		}(h)
+	}
+}
+
+// Wait blocks until all fired hooks have finished.
+func Wait() {
+	wg.Wait()
+}
+
+func subscribed(h config.Hook, event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func run(h config.Hook, ev Event) {
+	if h.Command != "" {
+		if err := runCommand(h.Command, ev); err != nil {
+			util.Log.Error("Hook command failed", "vid", ev.VID, "event", ev.Event, "err", err)
+		}
+	}
+	if h.Webhook != "" {
+		retries := h.Retries
+		if retries <= 0 {
+			retries = defaultWebhookRetries
+		}
+		if err := postWebhook(h.Webhook, ev, retries); err != nil {
+			util.Log.Error("Webhook failed", "vid", ev.VID, "event", ev.Event, "url", h.Webhook, "err", err)
+		}
+	}
+}
+
+func runCommand(command string, ev Event) error {
+	util.Log.Debug("Running hook command", "vid", ev.VID, "event", ev.Event)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"IWARADL_EVENT="+ev.Event,
+		"IWARADL_VID="+ev.VID,
+		"IWARADL_FILE="+ev.File,
+		"IWARADL_TITLE="+ev.Title,
+		"IWARADL_AUTHOR="+ev.Author,
+		"IWARADL_ERROR="+ev.Error,
+	)
+	// stdout may carry --progress=json, keep the hook's output apart
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func postWebhook(u string, ev Event, retries int) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		util.Log.Debug("Posting webhook", "vid", ev.VID, "event", ev.Event, "attempt", attempt, "retries", retries)
+		err = postOnce(u, body)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+	}
+}
+
+func postOnce(u string, body []byte) error {
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("http status code: %d", resp.StatusCode)
+	}
+	return nil
+}
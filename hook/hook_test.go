@@ -0,0 +1,185 @@
+package hook
+
+import (
+	"encoding/json"
+	"iwaradl/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testEvent() Event {
+	return Event{
+		Event:  EventFailed,
+		VID:    "abc",
+		Title:  "Summer Dance",
+		Author: "alice",
+		File:   "/videos/abc.mp4",
+		Error:  "http status code: 404",
+		Time:   time.Date(2026, 2, 20, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	out := filepath.Join(t.TempDir(), "env")
+	t.Setenv("HOOK_OUT", out)
+	Fire([]config.Hook{{Command: `env | grep '^IWARADL_' | sort > "$HOOK_OUT"`, Events: []string{EventFailed}}}, testEvent())
+	Wait()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"IWARADL_AUTHOR=alice",
+		"IWARADL_ERROR=http status code: 404",
+		"IWARADL_EVENT=failed",
+		"IWARADL_FILE=/videos/abc.mp4",
+		"IWARADL_TITLE=Summer Dance",
+		"IWARADL_VID=abc",
+	} {
+		if !strings.Contains(string(data), want+"\n") {
+			t.Errorf("hook env lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestCommandOutputGoesToStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	defer func(out, errOut *os.File) { os.Stdout, os.Stderr = out, errOut }(os.Stdout, os.Stderr)
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = stdout, stderr
+
+	Fire([]config.Hook{{Command: "echo moved"}}, testEvent())
+	Wait()
+	out, _ := os.ReadFile(stdout.Name())
+	errOut, _ := os.ReadFile(stderr.Name())
+	if len(out) != 0 || string(errOut) != "moved\n" {
+		t.Errorf("stdout %q, stderr %q, want the output on stderr", out, errOut)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate([]config.Hook{{Events: []string{EventFailed}}, {Command: "true", Events: []string{"finished"}}})
+	if err == nil {
+		t.Fatal("invalid hooks accepted")
+	}
+	for _, want := range []string{"hooks[0]: needs a command or a webhook", "hooks[1]: unknown event finished"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+	if err := Validate([]config.Hook{{Webhook: "http://x", Events: []string{EventCompleted}}}); err != nil {
+		t.Errorf("valid hooks rejected: %v", err)
+	}
+}
+
+func TestFireSkipsUnsubscribedHooks(t *testing.T) {
+	var posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer ts.Close()
+
+	Fire([]config.Hook{
+		{Webhook: ts.URL, Events: []string{EventCompleted}},
+		{Webhook: ts.URL},
+	}, testEvent())
+	Wait()
+	if n := posts.Load(); n != 1 {
+		t.Errorf("got %d posts, want 1 from the hook without events", n)
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	var got Event
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	want := testEvent()
+	if err := postWebhook(ts.URL, want, 1); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	if !got.Time.Equal(want.Time) {
+		t.Errorf("time = %v, want %v", got.Time, want.Time)
+	}
+	got.Time = want.Time
+	if got != want {
+		t.Errorf("payload = %+v, want %+v", got, want)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	if err := postWebhook(ts.URL, testEvent(), 3); err != nil {
+		t.Errorf("third attempt should succeed: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("got %d attempts, want 3", n)
+	}
+
+	calls.Store(0)
+	if err := postWebhook(ts.URL, testEvent(), 2); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("err = %v, want the last status", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d attempts, want 2", n)
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	defer func(c *http.Client, d time.Duration) { webhookClient, webhookRetryDelay = c, d }(webhookClient, webhookRetryDelay)
+	webhookClient = &http.Client{Timeout: 50 * time.Millisecond}
+	webhookRetryDelay = time.Millisecond
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	start := time.Now()
+	if err := postWebhook(ts.URL, testEvent(), 2); err == nil {
+		t.Error("a hanging webhook should time out")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("two timed out attempts took %v", d)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "iwaradl/cmd"
+import (
+	"iwaradl/cmd"
+	_ "time/tzdata" // --timezone works where the system has no zoneinfo, e.g. Windows
+)
 
 func main() {
 	cmd.Execute()
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a counter or gauge with at most one label, exposed in the
+// Prometheus text format.
+type Metric struct {
+	name   string
+	help   string
+	kind   string
+	label  string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Metric
+)
+
+var (
+	TasksByStatus         = NewGauge("iwaradl_tasks", "Tasks in the daemon store by status.", "status")
+	QueueDepth            = NewGauge("iwaradl_queue_depth", "Pending tasks waiting for the worker.", "")
+	DownloadedBytes       = NewCounter("iwaradl_downloaded_bytes_total", "Bytes written by video downloads.", "")
+	DownloadSpeed         = NewGauge("iwaradl_download_speed_bytes", "Current download throughput in bytes per second.", "")
+	DownloadsFinished     = NewCounter("iwaradl_downloads_total", "Finished download attempts by result.", "result")
+	APIRequests           = NewCounter("iwaradl_api_requests_total", "Requests to the Iwara API by HTTP status code.", "code")
+	CloudflareMitigations = NewCounter("iwaradl_cloudflare_mitigations_total", "Iwara API responses carrying a cf-mitigated header.", "")
+	TokenRefreshes        = NewCounter("iwaradl_token_refreshes_total", "Access token requests by result.", "result")
+)
+
+func NewCounter(name, help, label string) *Metric {
+	return register(&Metric{name: name, help: help, kind: "counter", label: label})
+}
+
+func NewGauge(name, help, label string) *Metric {
+	return register(&Metric{name: name, help: help, kind: "gauge", label: label})
+}
+
+func register(m *Metric) *Metric {
+	m.values = make(map[string]float64)
+	if m.label == "" {
+		m.values[""] = 0
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+	return m
+}
+
+// Inc adds one to the series with the given label value ("" if unlabeled).
+func (m *Metric) Inc(labelValue string) {
+	m.Add(labelValue, 1)
+}
+
+func (m *Metric) Add(labelValue string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelValue] += v
+}
+
+func (m *Metric) Set(labelValue string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelValue] = v
+}
+
+// WriteText writes all registered metrics in the Prometheus text format.
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	list := append([]*Metric(nil), registry...)
+	registryMu.Unlock()
+
+	for _, m := range list {
+		if _, err := io.WriteString(w, m.text()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Metric) text() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, k := range keys {
+		value := strconv.FormatFloat(m.values[k], 'g', -1, 64)
+		if m.label == "" {
+			fmt.Fprintf(&b, "%s %s\n", m.name, value)
+		} else {
+			fmt.Fprintf(&b, "%s{%s=\"%s\"} %s\n", m.name, m.label, escapeLabel(k), value)
+		}
+	}
+	return b.String()
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests by path.", "path")
+	c.Inc(`/a"b\c` + "\nd")
+	c.Add("/", 2.5)
+	g := NewGauge("test_speed", "Current speed.", "")
+	g.Set("", 3)
+
+	var b strings.Builder
+	if err := WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_requests_total Requests by path.
+# TYPE test_requests_total counter
+test_requests_total{path="/"} 2.5
+test_requests_total{path="/a\"b\\c\nd"} 1
+# HELP test_speed Current speed.
+# TYPE test_speed gauge
+test_speed 3
+`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("exposition lacks\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -1,33 +1,33 @@
 package server
 
 import (
-	"crypto/subtle"
+	"context"
 	"encoding/json"
-	"iwaradl/config"
 	"net/http"
 	"strings"
 )
 
+// authMiddleware accepts apiToken and the tokens in apiTokens. Routes check
+// the token's scopes with requireScope.
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := strings.TrimSpace(config.Cfg.ApiToken)
-		if token == "" {
-			writeUnauthorized(w)
-			return
-		}
-
 		provided := bearerToken(r.Header.Get("Authorization"))
 		if provided == "" {
 			writeUnauthorized(w)
 			return
 		}
 
-		if subtle.ConstantTimeCompare([]byte(token), []byte(provided)) != 1 {
+		t, ok := lookupToken(provided)
+		if !ok {
 			writeUnauthorized(w)
 			return
 		}
+		recordTokenUse(t.Name)
+		if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+			info.token = t.Name
+		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, t)))
 	})
 }
 
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/util"
+	"strconv"
+	"time"
+)
+
+// batches holds a reporter for every POST /api/tasks request whose tasks are
+// not all finished yet. Guarded by mu.
+var batches = make(map[string]*downloader.Reporter)
+
+func newBatchID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func batchReporter(batch string) *downloader.Reporter {
+	mu.Lock()
+	defer mu.Unlock()
+	r, ok := batches[batch]
+	if !ok {
+		r = downloader.NewReporter("batch-" + batch)
+		batches[batch] = r
+	}
+	return r
+}
+
+// takeFinishedBatchLocked removes and returns the reporter of batch once none
+// of its tasks is pending or running. Callers must hold mu.
+func takeFinishedBatchLocked(batch string) *downloader.Reporter {
+	r, ok := batches[batch]
+	if !ok {
+		return nil
+	}
+	for _, t := range store {
+		if t.Batch == batch && (t.Status == "pending" || t.Status == "running") {
+			return nil
+		}
+	}
+	delete(batches, batch)
+	return r
+}
+
+func writeBatchReport(r *downloader.Reporter) {
+	if r == nil || r.Empty() {
+		return
+	}
+	path, err := r.Write(config.Cfg.RootDir, config.Cfg.ReportMarkdown)
+	if err != nil {
+		util.Log.Error("Failed to write batch report", "err", err)
+		return
+	}
+	util.Log.Info("Batch report written", "file", path)
+}
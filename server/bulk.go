@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"iwaradl/downloader"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Actions of POST /api/tasks/bulk.
+const (
+	BulkCancel      = "cancel"       // pending tasks become failed with error class canceled
+	BulkRetry       = "retry"        // failed tasks are queued again
+	BulkDelete      = "delete"       // tasks that are not running are removed from the store
+	BulkSetPriority = "set_priority" // priority of any task
+)
+
+// BulkFilter selects tasks like the query parameters of GET /api/tasks.
+type BulkFilter struct {
+	Status     []string `json:"status,omitempty"`
+	ErrorClass []string `json:"error_class,omitempty"`
+	Author     string   `json:"author,omitempty"`
+	Q          string   `json:"q,omitempty"`
+	Since      string   `json:"since,omitempty"` // RFC 3339 time or a duration such as 24h
+}
+
+// BulkReq selects tasks by VIDs or by Filter, never both.
+type BulkReq struct {
+	Action   string      `json:"action"`
+	VIDs     []string    `json:"vids,omitempty"`
+	Filter   *BulkFilter `json:"filter,omitempty"`
+	Priority *int        `json:"priority,omitempty"` // required by set_priority
+}
+
+// BulkItem is the outcome for one selected task.
+type BulkItem struct {
+	VID    string `json:"vid"`
+	OK     bool   `json:"ok"`
+	Status string `json:"status,omitempty"` // status afterwards, deleted once removed
+	Error  string `json:"error,omitempty"`
+}
+
+type BulkResp struct {
+	Action    string     `json:"action"`
+	Matched   int        `json:"matched"`
+	Succeeded int        `json:"succeeded"`
+	Failed    int        `json:"failed"`
+	Results   []BulkItem `json:"results"`
+}
+
+// query validates req and turns its filter into a task query; it is nil when
+// req selects by VIDs.
+func (req *BulkReq) query() (*TaskQuery, error) {
+	switch req.Action {
+	case BulkCancel, BulkRetry, BulkDelete:
+	case BulkSetPriority:
+		if req.Priority == nil {
+			return nil, errors.New("set_priority needs priority")
+		}
+	default:
+		return nil, errors.New("invalid action, allowed values: cancel, retry, delete, set_priority")
+	}
+	if (len(req.VIDs) > 0) == (req.Filter != nil) {
+		return nil, errors.New("give either vids or filter")
+	}
+	if req.Filter == nil {
+		return nil, nil
+	}
+	f := req.Filter
+	q := &TaskQuery{
+		Statuses:     f.Status,
+		ErrorClasses: f.ErrorClass,
+		Author:       strings.TrimSpace(f.Author),
+		Q:            strings.TrimSpace(f.Q),
+	}
+	since, err := parseSince(f.Since)
+	if err != nil {
+		return nil, err
+	}
+	q.Since = since
+	if len(q.Statuses) == 0 && len(q.ErrorClasses) == 0 && q.Author == "" && q.Q == "" && q.Since.IsZero() {
+		// an empty filter would hit every task
+		return nil, errors.New("filter has no conditions")
+	}
+	return q, q.normalize()
+}
+
+// BulkTasks applies req to the selected tasks in one step. Tasks that do not
+// allow the action are reported as failed items and left unchanged.
+func BulkTasks(req BulkReq) (BulkResp, error) {
+	q, err := req.query()
+	if err != nil {
+		return BulkResp{}, err
+	}
+	resp := BulkResp{Action: req.Action, Results: []BulkItem{}}
+	batchSet := make(map[string]bool)
+	var finished []*downloader.Reporter
+
+	mu.Lock()
+	var vids []string
+	if q == nil {
+		for _, vid := range req.VIDs {
+			if vid = strings.TrimSpace(vid); vid != "" && !slices.Contains(vids, vid) {
+				vids = append(vids, vid)
+			}
+		}
+	} else {
+		var list []*Task
+		for _, t := range store {
+			if q.matches(t) {
+				list = append(list, t)
+			}
+		}
+		slices.SortFunc(list, q.compareTasks)
+		for _, t := range list {
+			vids = append(vids, t.VID)
+		}
+	}
+	for _, vid := range vids {
+		item := BulkItem{VID: vid}
+		if t, ok := store[vid]; !ok {
+			item.Error = "not found"
+		} else if item.Status, err = applyBulkLocked(req, t); err != nil {
+			item.Status = t.Status
+			item.Error = err.Error()
+		} else {
+			item.OK = true
+			batchSet[t.Batch] = true
+		}
+		if item.OK {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, item)
+	}
+	for batch := range batchSet {
+		if r := takeFinishedBatchLocked(batch); r != nil {
+			finished = append(finished, r)
+		}
+	}
+	mu.Unlock()
+
+	resp.Matched = len(vids)
+	for _, r := range finished {
+		writeBatchReport(r)
+	}
+	if req.Action == BulkRetry && resp.Succeeded > 0 {
+		wakeWorker()
+	}
+	return resp, nil
+}
+
+// applyBulkLocked applies the action of req to t and returns its new status.
+// Callers must hold mu.
+func applyBulkLocked(req BulkReq, t *Task) (string, error) {
+	active := t.Status == "running" || t.VID == activeTask
+	if req.Action == BulkCancel && t.VID == activeTask {
+		if t.ErrorClass == downloader.ErrClassCanceled {
+			return "", errors.New("task is already being cancelled")
+		}
+		cancelActiveLocked(t)
+		return t.Status, nil
+	}
+	if active && req.Action != BulkSetPriority {
+		return "", errors.New("task is being downloaded")
+	}
+	switch req.Action {
+	case BulkCancel:
+		if t.Status != "pending" {
+			return "", errors.New("only pending tasks can be cancelled")
+		}
+		t.Status = "failed"
+		t.LastError = "cancelled"
+		t.ErrorClass = downloader.ErrClassCanceled
+		t.FinishedAt = time.Now()
+	case BulkRetry:
+		if t.Status != "failed" {
+			return "", errors.New("only failed tasks can be retried")
+		}
+		t.Status = "pending"
+		t.Progress = 0
+		t.BytesDone = 0
+		t.Speed = 0
+		t.FinishedAt = time.Time{}
+	case BulkDelete:
+		delete(store, t.VID)
+		return "deleted", nil
+	case BulkSetPriority:
+		t.Priority = *req.Priority
+	}
+	return t.Status, nil
+}
+
+// POST /api/tasks/bulk
+func bulkTasks(w http.ResponseWriter, r *http.Request) {
+	var req BulkReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	resp, err := BulkTasks(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
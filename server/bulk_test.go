@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBulkTasks(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	setStore(t,
+		&Task{VID: "a", Status: "failed", CreatedAt: base, ErrorClass: "forbidden"},
+		&Task{VID: "b", Status: "failed", CreatedAt: base.Add(time.Hour), ErrorClass: "network"},
+		&Task{VID: "c", Status: "pending", CreatedAt: base.Add(2 * time.Hour)},
+		&Task{VID: "d", Status: "running", CreatedAt: base.Add(3 * time.Hour)},
+		&Task{VID: "e", Status: "completed", CreatedAt: base.Add(4 * time.Hour)},
+	)
+	// d is being downloaded by the worker
+	ctx, cancel := context.WithCancel(context.Background())
+	mu.Lock()
+	activeTask, activeCancel = "d", cancel
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		activeTask, activeCancel = "", nil
+		mu.Unlock()
+	})
+	status := func(vid string) string {
+		task, ok := GetTask(vid)
+		if !ok {
+			return "deleted"
+		}
+		return task.Status
+	}
+
+	resp, err := BulkTasks(BulkReq{Action: BulkRetry, Filter: &BulkFilter{Status: []string{"failed"}, ErrorClass: []string{"forbidden"}}})
+	if err != nil || resp.Matched != 1 || resp.Succeeded != 1 || status("a") != "pending" || status("b") != "failed" {
+		t.Fatalf("retry forbidden = %+v, %v", resp, err)
+	}
+
+	resp, err = BulkTasks(BulkReq{Action: BulkCancel, VIDs: []string{"c", "d", "x"}})
+	if err != nil || resp.Succeeded != 2 || resp.Failed != 1 {
+		t.Fatalf("cancel = %+v, %v", resp, err)
+	}
+	for _, vid := range []string{"c", "d"} {
+		if task, _ := GetTask(vid); task.Status != "failed" || task.ErrorClass != "canceled" {
+			t.Errorf("cancelled task = %+v", task)
+		}
+	}
+	if ctx.Err() == nil {
+		t.Error("cancelling the active task left its download running")
+	}
+	if !resp.Results[1].OK || resp.Results[1].Status != "failed" || resp.Results[2].Error != "not found" {
+		t.Errorf("cancel results = %+v", resp.Results)
+	}
+	if resp, _ = BulkTasks(BulkReq{Action: BulkCancel, VIDs: []string{"d"}}); resp.Succeeded != 0 {
+		t.Errorf("second cancel of the active task = %+v", resp)
+	}
+
+	priority := 7
+	if resp, err = BulkTasks(BulkReq{Action: BulkSetPriority, VIDs: []string{"a"}, Priority: &priority}); err != nil || resp.Succeeded != 1 {
+		t.Fatalf("set_priority = %+v, %v", resp, err)
+	}
+	if task, _ := GetTask("a"); task.Priority != 7 {
+		t.Errorf("priority = %d, want 7", task.Priority)
+	}
+
+	resp, err = BulkTasks(BulkReq{Action: BulkDelete, Filter: &BulkFilter{Status: []string{"completed", "failed", "running"}}})
+	if err != nil || resp.Matched != 4 || resp.Succeeded != 3 {
+		t.Fatalf("purge = %+v, %v", resp, err)
+	}
+	// d stays until the worker lets go of it
+	for vid, want := range map[string]string{"a": "pending", "b": "deleted", "c": "deleted", "d": "failed", "e": "deleted"} {
+		if got := status(vid); got != want {
+			t.Errorf("after purge %s is %s, want %s", vid, got, want)
+		}
+	}
+
+	for _, req := range []BulkReq{
+		{Action: "pause", VIDs: []string{"a"}},
+		{Action: BulkDelete},
+		{Action: BulkDelete, VIDs: []string{"a"}, Filter: &BulkFilter{Status: []string{"failed"}}},
+		{Action: BulkDelete, Filter: &BulkFilter{}},
+		{Action: BulkSetPriority, VIDs: []string{"a"}},
+	} {
+		if _, err := BulkTasks(req); err == nil {
+			t.Errorf("BulkTasks(%+v) accepted", req)
+		}
+	}
+}
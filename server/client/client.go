@@ -0,0 +1,206 @@
+// Package client is a typed Go client for the iwaradl daemon HTTP API
+// described by GET /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iwaradl/server"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one daemon.
+type Client struct {
+	BaseURL    string // e.g. http://127.0.0.1:23456
+	Token      string // apiToken of the daemon, or a token from its apiTokens
+	HTTPClient *http.Client
+}
+
+// Error is a non-2xx response from the daemon.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("daemon returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// New returns a client for the daemon at baseURL using token.
+func New(baseURL, token string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid daemon URL %q, want e.g. http://127.0.0.1:23456", baseURL)
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      strings.TrimSpace(token),
+		HTTPClient: &http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+// CreateTasks calls POST /api/tasks. URLs already queued on the daemon are
+// left out of the result; if none is new the daemon answers 422.
+func (c *Client) CreateTasks(ctx context.Context, urls []string, opts server.TaskOptions) ([]server.TaskResp, error) {
+	var tasks []server.TaskResp
+	err := c.do(ctx, http.MethodPost, "/api/tasks", server.CreateReq{URLs: urls, Options: opts}, &tasks)
+	return tasks, err
+}
+
+// ListTasks calls GET /api/tasks with the filters, ordering and page of q.
+func (c *Client) ListTasks(ctx context.Context, q server.TaskQuery) (server.TaskListResp, error) {
+	v := url.Values{}
+	if len(q.Statuses) > 0 {
+		v.Set("status", strings.Join(q.Statuses, ","))
+	}
+	if len(q.ErrorClasses) > 0 {
+		v.Set("error_class", strings.Join(q.ErrorClasses, ","))
+	}
+	if q.Author != "" {
+		v.Set("author", q.Author)
+	}
+	if q.Q != "" {
+		v.Set("q", q.Q)
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Order != "" {
+		v.Set("order", q.Order)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	path := "/api/tasks"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+	var list server.TaskListResp
+	err := c.do(ctx, http.MethodGet, path, nil, &list)
+	return list, err
+}
+
+// ListAllTasks calls ListTasks page after page, following next_cursor, and
+// returns every task selected by q with the total reported by the daemon.
+func (c *Client) ListAllTasks(ctx context.Context, q server.TaskQuery) (server.TaskListResp, error) {
+	all := server.TaskListResp{Tasks: []server.TaskResp{}}
+	for {
+		page, err := c.ListTasks(ctx, q)
+		if err != nil {
+			return all, err
+		}
+		all.Tasks = append(all.Tasks, page.Tasks...)
+		all.Total = page.Total
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// BulkTasks calls POST /api/tasks/bulk. Tasks the action does not apply to
+// are reported in the per-item results, not as an error.
+func (c *Client) BulkTasks(ctx context.Context, req server.BulkReq) (server.BulkResp, error) {
+	var resp server.BulkResp
+	err := c.do(ctx, http.MethodPost, "/api/tasks/bulk", req, &resp)
+	return resp, err
+}
+
+// GetTask calls GET /api/tasks/{vid}.
+func (c *Client) GetTask(ctx context.Context, vid string) (server.TaskResp, error) {
+	var task server.TaskResp
+	err := c.do(ctx, http.MethodGet, "/api/tasks/"+url.PathEscape(vid), nil, &task)
+	return task, err
+}
+
+// DeleteTask calls DELETE /api/tasks/{vid}.
+func (c *Client) DeleteTask(ctx context.Context, vid string) error {
+	return c.do(ctx, http.MethodDelete, "/api/tasks/"+url.PathEscape(vid), nil, nil)
+}
+
+// GetRateLimit calls GET /api/rate-limit.
+func (c *Client) GetRateLimit(ctx context.Context) (server.RateLimitResp, error) {
+	var rl server.RateLimitResp
+	err := c.do(ctx, http.MethodGet, "/api/rate-limit", nil, &rl)
+	return rl, err
+}
+
+// SetRateLimit calls PUT /api/rate-limit; "" or "0" removes the limit.
+func (c *Client) SetRateLimit(ctx context.Context, rate string) (server.RateLimitResp, error) {
+	var rl server.RateLimitResp
+	err := c.do(ctx, http.MethodPut, "/api/rate-limit", server.RateLimitReq{RateLimit: rate}, &rl)
+	return rl, err
+}
+
+// GetConfig calls GET /api/config.
+func (c *Client) GetConfig(ctx context.Context) (server.ConfigStatus, error) {
+	var status server.ConfigStatus
+	err := c.do(ctx, http.MethodGet, "/api/config", nil, &status)
+	return status, err
+}
+
+// ListTokens calls GET /api/tokens, which needs the admin scope.
+func (c *Client) ListTokens(ctx context.Context) ([]server.TokenResp, error) {
+	var tokens []server.TokenResp
+	err := c.do(ctx, http.MethodGet, "/api/tokens", nil, &tokens)
+	return tokens, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		msg := strings.TrimSpace(string(data))
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			msg = e.Error
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: msg}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
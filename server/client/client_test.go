@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"iwaradl/config"
+	"iwaradl/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	config.Cfg = config.Defaults()
+	config.Cfg.RootDir = t.TempDir()
+	config.Cfg.ApiToken = "secret"
+	ts := httptest.NewServer(server.NewRouter())
+	defer ts.Close()
+	ctx := context.Background()
+
+	bad, _ := New(ts.URL, "wrong")
+	var apiErr *Error
+	if _, err := bad.ListTasks(ctx, server.TaskQuery{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("ListTasks with a wrong token: err = %v, want 401", err)
+	}
+
+	c, err := New(ts.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := c.CreateTasks(ctx, []string{"https://www.iwara.tv/video/abc123"}, server.TaskOptions{MaxRetry: 2})
+	if err != nil {
+		t.Fatalf("CreateTasks: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].VID != "abc123@www.iwara.tv" || tasks[0].Options.MaxRetry != 2 {
+		t.Fatalf("CreateTasks = %+v", tasks)
+	}
+	task, err := c.GetTask(ctx, tasks[0].VID)
+	if err != nil || task.Status != "pending" {
+		t.Fatalf("GetTask = %+v, %v", task, err)
+	}
+	if list, err := c.ListTasks(ctx, server.TaskQuery{}); err != nil || len(list.Tasks) != 1 || list.Total != 1 {
+		t.Fatalf("ListTasks = %+v, %v", list, err)
+	}
+	if list, err := c.ListTasks(ctx, server.TaskQuery{Statuses: []string{"failed"}}); err != nil || len(list.Tasks) != 0 || list.Total != 0 {
+		t.Fatalf("ListTasks(status=failed) = %+v, %v", list, err)
+	}
+	if _, err := c.CreateTasks(ctx, []string{"https://www.iwara.tv/video/def456", "https://www.iwara.tv/video/ghi789"}, server.TaskOptions{}); err != nil {
+		t.Fatalf("CreateTasks: %v", err)
+	}
+	if list, err := c.ListAllTasks(ctx, server.TaskQuery{Limit: 1}); err != nil || len(list.Tasks) != 3 || list.Total != 3 {
+		t.Fatalf("ListAllTasks over pages of 1 = %+v, %v", list, err)
+	}
+	if _, err := c.ListTasks(ctx, server.TaskQuery{Sort: "size"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("ListTasks(sort=size): err = %v, want 400", err)
+	}
+	if err := c.DeleteTask(ctx, tasks[0].VID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	if _, err := c.GetTask(ctx, tasks[0].VID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("GetTask after delete: err = %v, want 404", err)
+	}
+
+	rl, err := c.SetRateLimit(ctx, "1M")
+	if err != nil || rl.BytesPerSecond == 0 {
+		t.Fatalf("SetRateLimit = %+v, %v", rl, err)
+	}
+	if rl, err = c.SetRateLimit(ctx, ""); err != nil || rl.BytesPerSecond != 0 {
+		t.Fatalf("SetRateLimit(\"\") = %+v, %v", rl, err)
+	}
+}
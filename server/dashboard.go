@@ -0,0 +1,50 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// webFS holds the dashboard. The page itself carries no data and is served
+// without auth; it asks for the API token and sends it with every API call.
+//
+//go:embed web
+var webFS embed.FS
+
+var webAssets, _ = fs.Sub(webFS, "web")
+
+// dashboardCSP keeps the dashboard to its own origin, so it works offline and
+// cannot load anything from a CDN.
+const dashboardCSP = "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'"
+
+func setDashboardHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Security-Policy", dashboardCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+}
+
+// GET /
+func getDashboard(w http.ResponseWriter, r *http.Request) {
+	page, err := fs.ReadFile(webAssets, "index.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setDashboardHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+// GET /assets/{file}
+func getDashboardAsset(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "file")
+	if name == "index.html" || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	setDashboardHeaders(w)
+	http.ServeFileFS(w, r, webAssets, name)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	ts := httptest.NewServer(NewRouter())
+	defer ts.Close()
+
+	for path, want := range map[string]string{
+		"/":                 "text/html",
+		"/assets/app.js":    "javascript",
+		"/assets/style.css": "text/css",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), want) {
+			t.Errorf("GET %s = %d %s, want 200 %s", path, resp.StatusCode, resp.Header.Get("Content-Type"), want)
+		}
+		if resp.Header.Get("Content-Security-Policy") != dashboardCSP {
+			t.Errorf("GET %s has no dashboard CSP", path)
+		}
+	}
+	for _, path := range []string{"/assets/index.html", "/assets/missing.js"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
+
+// The dashboard must work offline, so it may not load anything from another
+// origin.
+func TestDashboardHasNoRemoteAssets(t *testing.T) {
+	remote := regexp.MustCompile(`(?i)(src|href)\s*=\s*["']?(https?:)?//|url\(\s*["']?(https?:)?//|@import`)
+	err := fs.WalkDir(webAssets, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(webAssets, path)
+		if err != nil {
+			return err
+		}
+		if m := remote.Find(data); m != nil {
+			t.Errorf("%s loads a remote asset: %s", path, m)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,7 +2,13 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/metrics"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -14,11 +20,24 @@ type CreateReq struct {
 }
 
 type TaskResp struct {
-	VID       string             `json:"vid"`
-	Status    string             `json:"status"`
-	Progress  float32            `json:"progress"`
-	CreatedAt time.Time          `json:"created_at"`
-	Options   TaskOptionsSummary `json:"options"`
+	VID        string             `json:"vid"`
+	Status     string             `json:"status"`
+	Progress   float32            `json:"progress"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Options    TaskOptionsSummary `json:"options"`
+	Priority   int                `json:"priority"`
+	Title      string             `json:"title,omitempty"`
+	Author     string             `json:"author,omitempty"`
+	Host       string             `json:"host"`
+	OutputPath string             `json:"output_path,omitempty"`
+	BytesTotal int64              `json:"bytes_total"`
+	BytesDone  int64              `json:"bytes_done"`
+	Speed      float64            `json:"speed"` // bytes per second
+	StartedAt  *time.Time         `json:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Attempts   int                `json:"attempts"`
+	LastError  string             `json:"last_error,omitempty"`
+	ErrorClass string             `json:"error_class,omitempty"`
 }
 
 // POST /api/tasks
@@ -32,6 +51,17 @@ func createTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "urls empty", http.StatusUnprocessableEntity)
 		return
 	}
+	if t := tokenFrom(r.Context()); t != nil {
+		cfgMu.RLock()
+		rootDir := config.Cfg.RootDir
+		cfgMu.RUnlock()
+		opts, err := t.restrict(req.Options, rootDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		req.Options = opts
+	}
 	tl, err := CreateTask(req.URLs, req.Options)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
@@ -58,14 +88,84 @@ func getTask(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, taskToResp(t))
 }
 
+// TaskListResp is returned by GET /api/tasks.
+type TaskListResp struct {
+	Tasks      []TaskResp `json:"tasks"`
+	Total      int        `json:"total"`                 // matching tasks over all pages
+	NextCursor string     `json:"next_cursor,omitempty"` // pass as cursor for the next page
+}
+
 // GET /api/tasks
 func listTasks(w http.ResponseWriter, r *http.Request) {
-	tasks := ListTasks()
-	list := make([]TaskResp, len(tasks))
-	for i, t := range tasks {
-		list[i] = taskToResp(t)
+	q, err := parseTaskQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	respondJSON(w, http.StatusOK, list)
+	page, err := QueryTasks(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := TaskListResp{Tasks: make([]TaskResp, len(page.Tasks)), Total: page.Total, NextCursor: page.NextCursor}
+	for i, t := range page.Tasks {
+		resp.Tasks[i] = taskToResp(t)
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func parseTaskQuery(r *http.Request) (TaskQuery, error) {
+	v := r.URL.Query()
+	q := TaskQuery{
+		Author: strings.TrimSpace(v.Get("author")),
+		Q:      strings.TrimSpace(v.Get("q")),
+		Sort:   v.Get("sort"),
+		Order:  v.Get("order"),
+		Cursor: v.Get("cursor"),
+	}
+	q.Statuses = splitValues(v["status"])
+	q.ErrorClasses = splitValues(v["error_class"])
+	since, err := parseSince(v.Get("since"))
+	if err != nil {
+		return q, err
+	}
+	q.Since = since
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// splitValues flattens repeated and comma-separated query values.
+func splitValues(values []string) []string {
+	var list []string
+	for _, s := range values {
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+// parseSince accepts an RFC 3339 time, or a duration back from now such as
+// 24h. An empty string is the zero time.
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, errors.New("invalid since, use an RFC 3339 time or a duration like 24h")
 }
 
 // DELETE /api/tasks/{vid}
@@ -80,6 +180,53 @@ func deleteTask(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type RateLimitReq struct {
+	RateLimit string `json:"rate_limit"`
+}
+
+type RateLimitResp struct {
+	RateLimit      string `json:"rate_limit"`
+	BytesPerSecond int64  `json:"bytes_per_second"`
+}
+
+// GET /api/rate-limit
+func getRateLimit(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, rateLimitResp())
+}
+
+// PUT /api/rate-limit
+func setRateLimit(w http.ResponseWriter, r *http.Request) {
+	var req RateLimitReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	rate, err := downloader.ParseRateLimit(req.RateLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	// under cfgMu so a concurrent reload cannot leave the limiter and the
+	// config disagreeing
+	cfgMu.Lock()
+	downloader.SetGlobalRateLimit(rate)
+	config.Cfg.RateLimit = strings.TrimSpace(req.RateLimit)
+	cfgMu.Unlock()
+	respondJSON(w, http.StatusOK, rateLimitResp())
+}
+
+// GET /api/config
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, configStatus())
+}
+
+// GET /metrics
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	updateTaskMetrics()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = metrics.WriteText(w)
+}
+
 /* ---------- 工具 ---------- */
 func respondJSON(w http.ResponseWriter, code int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -87,12 +234,40 @@ func respondJSON(w http.ResponseWriter, code int, v interface{}) {
 	json.NewEncoder(w).Encode(v)
 }
 
+func rateLimitResp() RateLimitResp {
+	rate := downloader.GlobalRateLimit()
+	return RateLimitResp{
+		RateLimit:      downloader.FormatRateLimit(rate),
+		BytesPerSecond: rate,
+	}
+}
+
 func taskToResp(t *Task) TaskResp {
 	return TaskResp{
-		VID:       t.VID,
-		Status:    t.Status,
-		Progress:  t.Progress,
-		CreatedAt: t.CreatedAt,
-		Options:   t.OptionsSummary,
+		VID:        t.VID,
+		Status:     t.Status,
+		Progress:   t.Progress,
+		CreatedAt:  t.CreatedAt,
+		Options:    t.OptionsSummary,
+		Priority:   t.Priority,
+		Title:      t.Title,
+		Author:     t.Author,
+		Host:       t.Host,
+		OutputPath: t.OutputPath,
+		BytesTotal: t.BytesTotal,
+		BytesDone:  t.BytesDone,
+		Speed:      t.Speed,
+		StartedAt:  timePtr(t.StartedAt),
+		FinishedAt: timePtr(t.FinishedAt),
+		Attempts:   t.Attempts,
+		LastError:  t.LastError,
+		ErrorClass: t.ErrorClass,
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
 	}
+	return &t
 }
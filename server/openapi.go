@@ -0,0 +1,18 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents the routes in NewRouter. Keep it in sync when adding
+// or changing an endpoint; TestOpenAPIMatchesRoutes checks the paths.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// GET /api/openapi.json
+func getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPISpec)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	routes := make(map[string]bool)
+	err := chi.Walk(NewRouter().(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = strings.ReplaceAll(route, "/*/", "/")
+		key := strings.ToLower(method) + " " + route
+		routes[key] = true
+		if _, ok := doc.Paths[route][strings.ToLower(method)]; !ok {
+			t.Errorf("route %s %s is missing from openapi.json", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if method == "parameters" {
+				continue
+			}
+			if !routes[method+" "+path] {
+				t.Errorf("openapi.json documents %s %s, which is not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIMatchesTypes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	types := map[string]any{
+		"CreateTasksRequest": CreateReq{},
+		"TaskOptions":        TaskOptions{},
+		"TaskOptionsSummary": TaskOptionsSummary{},
+		"Task":               TaskResp{},
+		"TaskList":           TaskListResp{},
+		"TokenInfo":          TokenResp{},
+		"BulkRequest":        BulkReq{},
+		"BulkFilter":         BulkFilter{},
+		"BulkItem":           BulkItem{},
+		"BulkResult":         BulkResp{},
+		"RateLimit":          RateLimitResp{},
+		"RateLimitRequest":   RateLimitReq{},
+		"ConfigStatus":       ConfigStatus{},
+	}
+	for name, v := range types {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing", name)
+			continue
+		}
+		var want, got []string
+		rt := reflect.TypeOf(v)
+		for i := 0; i < rt.NumField(); i++ {
+			tag, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+			if tag != "" && tag != "-" {
+				want = append(want, tag)
+			}
+		}
+		for prop := range schema.Properties {
+			got = append(got, prop)
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(want, got) {
+			t.Errorf("schema %s has properties %v, %T has %v", name, got, v, want)
+		}
+	}
+}
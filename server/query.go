@@ -0,0 +1,188 @@
+package server
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Page sizes of GET /api/tasks. A larger limit is lowered to the maximum.
+const (
+	DefaultTaskPageSize = 100
+	MaxTaskPageSize     = 1000
+)
+
+// TaskQuery selects and orders tasks for GET /api/tasks.
+type TaskQuery struct {
+	Statuses     []string  // any of them, empty for all
+	ErrorClasses []string  // any of them, empty for all
+	Author       string    // author username, case-insensitive
+	Q            string    // substring of the title or video ID, case-insensitive
+	Since        time.Time // created at or after
+	Sort         string    // created_at (default), priority or progress
+	Order        string    // asc or desc; created_at defaults to asc, the others to desc
+	Limit        int       // page size, 0 for DefaultTaskPageSize
+	Cursor       string    // next_cursor of the previous page
+}
+
+// TaskPage is one page of a task query.
+type TaskPage struct {
+	Tasks      []*Task
+	Total      int    // tasks matching the filters, over all pages
+	NextCursor string // empty on the last page
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of the last task of a page. It carries the sort
+// values rather than an offset, so tasks created meanwhile do not shift pages.
+type cursor struct {
+	Sort      string  `json:"s"`
+	Order     string  `json:"o"`
+	Priority  int     `json:"p,omitempty"`
+	Progress  float32 `json:"g,omitempty"`
+	CreatedAt int64   `json:"t"`
+	VID       string  `json:"v"`
+}
+
+func (q *TaskQuery) normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = "created_at"
+	case "created_at", "priority", "progress":
+	default:
+		return fmt.Errorf("invalid sort %q, allowed values: created_at, priority, progress", q.Sort)
+	}
+	switch q.Order {
+	case "":
+		q.Order = "desc"
+		if q.Sort == "created_at" {
+			q.Order = "asc"
+		}
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("invalid order %q, allowed values: asc, desc", q.Order)
+	}
+	switch {
+	case q.Limit < 0:
+		return errors.New("limit must be at least 0")
+	case q.Limit == 0:
+		q.Limit = DefaultTaskPageSize
+	case q.Limit > MaxTaskPageSize:
+		q.Limit = MaxTaskPageSize
+	}
+	return nil
+}
+
+// compareTasks orders tasks by the query's sort key, then by creation time
+// and ID so the order is stable.
+func (q *TaskQuery) compareTasks(a, b *Task) int {
+	var c int
+	switch q.Sort {
+	case "priority":
+		c = cmp.Compare(a.Priority, b.Priority)
+	case "progress":
+		c = cmp.Compare(a.Progress, b.Progress)
+	}
+	if c == 0 {
+		c = a.CreatedAt.Compare(b.CreatedAt)
+		if q.Sort != "created_at" {
+			// ties on priority or progress are served oldest first
+			c = -c
+		}
+	}
+	if c == 0 {
+		c = strings.Compare(a.VID, b.VID)
+	}
+	if q.Order == "desc" {
+		c = -c
+	}
+	return c
+}
+
+func (q *TaskQuery) matches(t *Task) bool {
+	if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, t.Status) {
+		return false
+	}
+	if len(q.ErrorClasses) > 0 && !slices.Contains(q.ErrorClasses, t.ErrorClass) {
+		return false
+	}
+	if q.Author != "" && !strings.EqualFold(q.Author, t.Author) {
+		return false
+	}
+	if q.Q != "" {
+		needle := strings.ToLower(q.Q)
+		if !strings.Contains(strings.ToLower(t.Title), needle) && !strings.Contains(strings.ToLower(t.VID), needle) {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && t.CreatedAt.Before(q.Since) {
+		return false
+	}
+	return true
+}
+
+func (q *TaskQuery) encodeCursor(t *Task) string {
+	data, _ := json.Marshal(cursor{
+		Sort: q.Sort, Order: q.Order,
+		Priority: t.Priority, Progress: t.Progress,
+		CreatedAt: t.CreatedAt.UnixNano(), VID: t.VID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q *TaskQuery) decodeCursor() (*Task, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != q.Sort || c.Order != q.Order {
+		return nil, errInvalidCursor
+	}
+	return &Task{Priority: c.Priority, Progress: c.Progress, CreatedAt: time.Unix(0, c.CreatedAt), VID: c.VID}, nil
+}
+
+// QueryTasks returns the page of tasks selected by q.
+func QueryTasks(q TaskQuery) (TaskPage, error) {
+	if err := q.normalize(); err != nil {
+		return TaskPage{}, err
+	}
+	var after *Task
+	if q.Cursor != "" {
+		var err error
+		if after, err = q.decodeCursor(); err != nil {
+			return TaskPage{}, err
+		}
+	}
+
+	mu.RLock()
+	list := make([]*Task, 0, len(store))
+	for _, t := range store {
+		if q.matches(t) {
+			list = append(list, cloneTask(t))
+		}
+	}
+	mu.RUnlock()
+	slices.SortFunc(list, q.compareTasks)
+
+	page := TaskPage{Total: len(list)}
+	if after != nil {
+		i, _ := slices.BinarySearchFunc(list, after, q.compareTasks)
+		if i < len(list) && q.compareTasks(list[i], after) == 0 {
+			i++
+		}
+		list = list[i:]
+	}
+	if len(list) > q.Limit {
+		list = list[:q.Limit]
+		page.NextCursor = q.encodeCursor(list[len(list)-1])
+	}
+	page.Tasks = list
+	return page, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func setStore(t *testing.T, tasks ...*Task) {
+	t.Helper()
+	mu.Lock()
+	old := store
+	store = make(map[string]*Task)
+	for _, task := range tasks {
+		store[task.VID] = task
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		store = old
+		mu.Unlock()
+	})
+}
+
+func vids(tasks []*Task) []string {
+	var list []string
+	for _, t := range tasks {
+		list = append(list, t.VID)
+	}
+	return list
+}
+
+func TestQueryTasks(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	setStore(t,
+		&Task{VID: "a", Status: "completed", CreatedAt: base, Progress: 1, Title: "First Dance", Author: "alice"},
+		&Task{VID: "b", Status: "pending", CreatedAt: base.Add(time.Hour), Priority: 5, Author: "bob"},
+		&Task{VID: "c", Status: "failed", CreatedAt: base.Add(2 * time.Hour), Progress: 0.5, Author: "Alice"},
+		&Task{VID: "d", Status: "pending", CreatedAt: base.Add(2 * time.Hour), Priority: 5, Title: "dance again"},
+	)
+
+	tests := []struct {
+		name string
+		q    TaskQuery
+		want []string
+	}{
+		{"default order", TaskQuery{}, []string{"a", "b", "c", "d"}},
+		{"status", TaskQuery{Statuses: []string{"pending", "failed"}}, []string{"b", "c", "d"}},
+		{"author", TaskQuery{Author: "ALICE"}, []string{"a", "c"}},
+		{"title", TaskQuery{Q: "dance"}, []string{"a", "d"}},
+		{"since", TaskQuery{Since: base.Add(90 * time.Minute)}, []string{"c", "d"}},
+		{"priority", TaskQuery{Sort: "priority"}, []string{"b", "d", "a", "c"}},
+		{"progress asc", TaskQuery{Sort: "progress", Order: "asc"}, []string{"d", "b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		page, err := QueryTasks(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := vids(page.Tasks); !slices.Equal(got, tt.want) || page.Total != len(tt.want) {
+			t.Errorf("%s: got %v (total %d), want %v", tt.name, got, page.Total, tt.want)
+		}
+	}
+
+	var got []string
+	q := TaskQuery{Sort: "priority", Limit: 3}
+	for {
+		page, err := QueryTasks(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 4 {
+			t.Fatalf("paged total = %d, want 4", page.Total)
+		}
+		got = append(got, vids(page.Tasks)...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if want := []string{"b", "d", "a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+
+	if _, err := QueryTasks(TaskQuery{Sort: "created_at", Cursor: q.Cursor}); !errors.Is(err, errInvalidCursor) {
+		t.Errorf("cursor of another sort: err = %v, want %v", err, errInvalidCursor)
+	}
+	if _, err := QueryTasks(TaskQuery{Sort: "size"}); err == nil {
+		t.Error("unknown sort accepted")
+	}
+}
+
+func TestQueryTasksPageSize(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tasks []*Task
+	for i := range MaxTaskPageSize + 50 {
+		tasks = append(tasks, &Task{VID: fmt.Sprintf("v%04d", i), Status: "pending", CreatedAt: base.Add(time.Duration(i) * time.Second)})
+	}
+	setStore(t, tasks...)
+
+	for _, tt := range []struct{ limit, want int }{
+		{0, DefaultTaskPageSize},
+		{10, 10},
+		{MaxTaskPageSize + 1, MaxTaskPageSize},
+	} {
+		page, err := QueryTasks(TaskQuery{Limit: tt.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Tasks) != tt.want || page.NextCursor == "" || page.Total != len(tasks) {
+			t.Errorf("limit %d: got %d tasks of %d, cursor %q, want %d and a cursor", tt.limit, len(page.Tasks), page.Total, page.NextCursor, tt.want)
+		}
+	}
+}
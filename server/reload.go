@@ -0,0 +1,253 @@
+package server
+
+import (
+	"errors"
+	"iwaradl/api"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"iwaradl/hook"
+	"iwaradl/util"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configPollInterval is how often config.yaml is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// Keys applied right away, and keys applied once the running task finishes
+// because a download run reads them from config.Cfg while it runs.
+var (
+	immediateKeys   = []string{"apiToken", "apiTokens", "rateLimit", "hooks"}
+	betweenRunsKeys = []string{"threadNum", "filenameTemplate", "proxyUrl", "rules"}
+)
+
+// ConfigStatus is returned by GET /api/config.
+type ConfigStatus struct {
+	File            string         `json:"file"`
+	Reloads         int            `json:"reloads"`
+	LastReload      *time.Time     `json:"last_reload,omitempty"`
+	LastError       string         `json:"last_error,omitempty"`
+	Applied         []string       `json:"applied"`          // keys changed by the last reload
+	Pending         []string       `json:"pending"`          // waiting for the running task to finish
+	RestartRequired []string       `json:"restart_required"` // changed but only read at startup
+	Config          map[string]any `json:"config"`           // effective config, secrets masked
+}
+
+var (
+	// cfgMu guards config.Cfg in the daemon. Every write takes it; the
+	// downloader only reads config.Cfg, and keys it reads during a run are
+	// written between runs.
+	cfgMu        sync.RWMutex
+	configLoader func() (config.Config, error)
+	reloadMu     sync.Mutex
+	startupCfg   config.Config // config the daemon started with
+	loadedCfg    config.Config // config of the last successful load
+	reloadStatus = ConfigStatus{Applied: []string{}, Pending: []string{}, RestartRequired: []string{}}
+)
+
+// SetConfigLoader sets the function that re-reads the configuration, with
+// the same precedence of file, environment and flags as at startup. Without
+// it the daemon does not reload its config.
+func SetConfigLoader(load func() (config.Config, error)) {
+	configLoader = load
+}
+
+// watchConfig reloads the configuration when the config file changes or the
+// process receives SIGHUP.
+func watchConfig() {
+	if configLoader == nil {
+		return
+	}
+	cfgMu.RLock()
+	startupCfg = config.Cfg
+	loadedCfg = config.Cfg
+	cfgMu.RUnlock()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	last := statConfigFile()
+	for {
+		select {
+		case <-hup:
+			util.Log.Info("Received SIGHUP, reloading config")
+		case <-ticker.C:
+			cur := statConfigFile()
+			if cur == last {
+				continue
+			}
+			last = cur
+			util.Log.Info("Config file changed, reloading", "file", config.File())
+		}
+		last = statConfigFile()
+		reloadConfig()
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statConfigFile() fileStamp {
+	info, err := os.Stat(config.File())
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reloadConfig loads the configuration and applies the live keys that changed
+// since the last load, so a reload keeps e.g. a rate limit set through the
+// API unless the file changes it too. A config that fails to load or validate
+// is rejected as a whole.
+func reloadConfig() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := configLoader()
+	if err == nil {
+		err = validateLiveConfig(next)
+	}
+	if err != nil {
+		util.Log.Error("Config reload rejected", "err", err)
+		setReloadStatus(func(s *ConfigStatus) { s.LastError = err.Error() })
+		return
+	}
+
+	var immediate, betweenRuns, restart []string
+	for _, key := range config.ChangedKeys(loadedCfg, next) {
+		switch {
+		case slices.Contains(immediateKeys, key):
+			immediate = append(immediate, key)
+		case slices.Contains(betweenRunsKeys, key):
+			betweenRuns = append(betweenRuns, key)
+		}
+	}
+	for _, key := range config.ChangedKeys(startupCfg, next) {
+		if !slices.Contains(immediateKeys, key) && !slices.Contains(betweenRunsKeys, key) {
+			restart = append(restart, key)
+		}
+	}
+	loadedCfg = next
+
+	cfgMu.Lock()
+	if slices.Contains(immediate, "apiToken") {
+		config.Cfg.ApiToken = next.ApiToken
+	}
+	if slices.Contains(immediate, "apiTokens") {
+		config.Cfg.ApiTokens = next.ApiTokens
+	}
+	if slices.Contains(immediate, "rateLimit") {
+		config.Cfg.RateLimit = next.RateLimit
+		rate, _ := downloader.ParseRateLimit(next.RateLimit)
+		downloader.SetGlobalRateLimit(rate)
+	}
+	if slices.Contains(immediate, "hooks") {
+		config.Cfg.Hooks = next.Hooks
+	}
+	cfgMu.Unlock()
+
+	now := time.Now()
+	setReloadStatus(func(s *ConfigStatus) {
+		s.Reloads++
+		s.LastReload = &now
+		s.LastError = ""
+		s.Applied = append([]string{}, immediate...)
+		for _, key := range betweenRuns {
+			if !slices.Contains(s.Pending, key) {
+				s.Pending = append(s.Pending, key)
+			}
+		}
+		s.RestartRequired = append([]string{}, restart...)
+	})
+	if len(betweenRuns) > 0 {
+		// queued behind the running task, so later reloads are not held up
+		util.Log.Info("Applying config change once no download is running", "keys", betweenRuns)
+		downloader.ApplyBetweenRuns(func() {
+			cfgMu.Lock()
+			config.Cfg.ThreadNum = next.ThreadNum
+			config.Cfg.FilenameTemplate = next.FilenameTemplate
+			config.Cfg.ProxyUrl = next.ProxyUrl
+			config.Cfg.Rules = next.Rules
+			cfgMu.Unlock()
+			if slices.Contains(betweenRuns, "proxyUrl") {
+				// rebuild the default client with the new proxy
+				if err := api.UseAccount(api.Default().Account()); err != nil {
+					util.Log.Error("Failed to apply proxy", "err", err)
+				}
+			}
+			setReloadStatus(func(s *ConfigStatus) {
+				for _, key := range betweenRuns {
+					s.Pending = slices.DeleteFunc(s.Pending, func(k string) bool { return k == key })
+					if !slices.Contains(s.Applied, key) {
+						s.Applied = append(s.Applied, key)
+					}
+				}
+			})
+			util.Log.Info("Applied pending config change", "keys", betweenRuns)
+		})
+	}
+	util.Log.Info("Config reloaded", "applied", immediate, "pending", betweenRuns, "restart_required", restart)
+}
+
+func validateLiveConfig(cfg config.Config) error {
+	if cfg.ApiToken == "" && len(cfg.ApiTokens) == 0 {
+		return errors.New("apiToken or apiTokens is required in daemon mode")
+	}
+	if err := ValidateTokens(cfg); err != nil {
+		return err
+	}
+	if cfg.ThreadNum < 1 {
+		return errors.New("threadNum must be at least 1")
+	}
+	if cfg.ProxyUrl != "" {
+		if err := api.ValidateProxyURL(cfg.ProxyUrl); err != nil {
+			return errors.New("proxyUrl: " + err.Error())
+		}
+	}
+	if cfg.FilenameTemplate != "" {
+		if err := downloader.ValidateTemplate(cfg.FilenameTemplate); err != nil {
+			return errors.New("filenameTemplate: " + err.Error())
+		}
+	}
+	if _, err := downloader.ParseRateLimit(cfg.RateLimit); err != nil {
+		return errors.New("rateLimit: " + err.Error())
+	}
+	if err := downloader.ValidateRules(cfg.Rules); err != nil {
+		return err
+	}
+	return hook.Validate(cfg.Hooks)
+}
+
+func setReloadStatus(fn func(s *ConfigStatus)) {
+	cfgMu.Lock()
+	defer cfgMu.Unlock()
+	fn(&reloadStatus)
+}
+
+func configStatus() ConfigStatus {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	s := reloadStatus
+	s.File = config.File()
+	s.Applied = slices.Clone(s.Applied)
+	s.Pending = slices.Clone(s.Pending)
+	s.RestartRequired = slices.Clone(s.RestartRequired)
+
+	// go through YAML so the keys match config.yaml
+	var m map[string]any
+	if data, err := yaml.Marshal(config.Masked(config.Cfg)); err == nil {
+		_ = yaml.Unmarshal(data, &m)
+	}
+	s.Config = m
+	return s
+}
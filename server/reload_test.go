@@ -0,0 +1,99 @@
+package server
+
+import (
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"slices"
+	"testing"
+	"time"
+)
+
+// A reload during a download applies immediate keys right away and queues
+// the others until the download ends.
+func TestReloadDuringRun(t *testing.T) {
+	config.Cfg = config.Defaults()
+	config.Cfg.RootDir = t.TempDir()
+	config.Cfg.ApiToken = "old"
+	loadedCfg = config.Cfg
+	reloadStatus = ConfigStatus{Applied: []string{}, Pending: []string{}, RestartRequired: []string{}}
+
+	next := config.Cfg
+	configLoader = func() (config.Config, error) { return next, nil }
+	defer func() { configLoader = nil }()
+
+	// hold the run lock the way a running download does
+	release, running := make(chan struct{}), make(chan struct{})
+	go downloader.ApplyBetweenRuns(func() {
+		close(running)
+		<-release
+	})
+	<-running
+
+	reloaded := make(chan struct{})
+	go func() {
+		next.ThreadNum = config.Cfg.ThreadNum + 1
+		reloadConfig()
+		next.ApiToken = "new"
+		reloadConfig()
+		close(reloaded)
+	}()
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		close(release)
+		t.Fatal("reload waited for the running download")
+	}
+
+	s := configStatus()
+	cfgMu.RLock()
+	token, threads := config.Cfg.ApiToken, config.Cfg.ThreadNum
+	cfgMu.RUnlock()
+	if token != "new" {
+		t.Errorf("apiToken = %q during the run, want it applied", token)
+	}
+	if threads == next.ThreadNum || !slices.Equal(s.Pending, []string{"threadNum"}) {
+		t.Errorf("threadNum = %d, pending %v, want it queued", threads, s.Pending)
+	}
+
+	close(release)
+	// the run ends through ApplyBetweenRuns here, so wait for the queue to drain
+	deadline := time.Now().Add(time.Second)
+	for {
+		cfgMu.RLock()
+		threads = config.Cfg.ThreadNum
+		cfgMu.RUnlock()
+		if threads == next.ThreadNum || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s = configStatus()
+	if threads != next.ThreadNum || len(s.Pending) != 0 || !slices.Contains(s.Applied, "threadNum") {
+		t.Errorf("after the run: threadNum = %d, status %+v", threads, s)
+	}
+}
+
+func TestReloadHooks(t *testing.T) {
+	config.Cfg = config.Defaults()
+	config.Cfg.ApiToken = "tok"
+	loadedCfg = config.Cfg
+	startupCfg = config.Cfg
+	reloadStatus = ConfigStatus{Applied: []string{}, Pending: []string{}, RestartRequired: []string{}}
+
+	next := config.Cfg
+	configLoader = func() (config.Config, error) { return next, nil }
+	defer func() { configLoader = nil }()
+
+	next.Hooks = []config.Hook{{Webhook: "http://127.0.0.1:1/hook", Events: []string{"finished"}}}
+	reloadConfig()
+	if s := configStatus(); len(configuredHooks()) != 0 || s.LastError == "" {
+		t.Errorf("invalid hooks applied, status %+v", s)
+	}
+
+	next.Hooks[0].Events = []string{"completed"}
+	reloadConfig()
+	s := configStatus()
+	if hooks := configuredHooks(); len(hooks) != 1 || !slices.Equal(s.Applied, []string{"hooks"}) || len(s.RestartRequired) != 0 {
+		t.Errorf("hooks = %v, status %+v, want them applied", hooks, s)
+	}
+}
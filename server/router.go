@@ -1,9 +1,12 @@
 package server
 
 import (
+	"context"
+	"iwaradl/util"
 	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,20 +14,68 @@ import (
 
 func NewRouter() http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.Logger, middleware.Recoverer)
+	r.Use(requestLogger, middleware.Recoverer)
+
+	r.Get("/", getDashboard)
+	r.Get("/assets/{file}", getDashboardAsset)
+	r.With(authMiddleware, requireScope(ScopeRead)).Get("/metrics", getMetrics)
 
 	r.Route("/api", func(r chi.Router) {
-		r.Use(authMiddleware)
-		r.Post("/tasks", createTask)
-		r.Get("/tasks", listTasks)
-		r.Get("/tasks/{vid}", getTask)
-		r.Delete("/tasks/{vid}", deleteTask)
+		r.Get("/openapi.json", getOpenAPI)
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware)
+			r.With(requireScope(ScopeEnqueue)).Post("/tasks", createTask)
+			r.Group(func(r chi.Router) {
+				r.Use(requireScope(ScopeRead))
+				r.Get("/tasks", listTasks)
+				r.Get("/tasks/{vid}", getTask)
+				r.Get("/rate-limit", getRateLimit)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(requireScope(ScopeAdmin))
+				r.Post("/tasks/bulk", bulkTasks)
+				r.Delete("/tasks/{vid}", deleteTask)
+				r.Put("/rate-limit", setRateLimit)
+				r.Get("/config", getConfig)
+				r.Get("/tokens", getTokens)
+			})
+		})
 	})
 	return r
 }
 
+// requestInfo collects what later handlers learn about a request for its
+// log line.
+type requestInfo struct {
+	token string // name of the API token used
+}
+
+type requestInfoKey struct{}
+
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		info := &requestInfo{}
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"remote", r.RemoteAddr,
+			"duration", time.Since(start),
+		}
+		if info.token != "" {
+			attrs = append(attrs, "token", info.token)
+		}
+		util.Log.Info("HTTP request", attrs...)
+	})
+}
+
 func RunServer(bindAddr string, port int) error {
 	StartWorker()
+	go watchConfig()
 	addr := net.JoinHostPort(bindAddr, strconv.Itoa(port))
 	return http.ListenAndServe(addr, NewRouter())
 }
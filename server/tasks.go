@@ -1,13 +1,17 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"iwaradl/api"
 	"iwaradl/config"
 	"iwaradl/downloader"
-	"net/url"
+	"iwaradl/hook"
+	"iwaradl/metrics"
+	"iwaradl/util"
+	"slices"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 )
 
@@ -19,6 +23,10 @@ type TaskOptions struct {
 	Cookie           string `json:"cookie,omitempty"`
 	MaxRetry         int    `json:"max_retry,omitempty"`
 	FilenameTemplate string `json:"filename_template,omitempty"`
+	RateLimit        string `json:"rate_limit,omitempty"`
+	Account          string `json:"account,omitempty"`
+	Priority         int    `json:"priority,omitempty"` // higher runs first
+	DirPrefix        string `json:"-"`                  // absolute directory a restricted token keeps downloads in
 }
 
 type TaskOptionsSummary struct {
@@ -27,15 +35,32 @@ type TaskOptionsSummary struct {
 	CookieSet        bool   `json:"cookie_set"`
 	MaxRetry         int    `json:"max_retry"`
 	FilenameTemplate string `json:"filename_template"`
+	RateLimit        string `json:"rate_limit,omitempty"`
+	Account          string `json:"account,omitempty"`
 }
 
 type Task struct {
 	VID            string
-	Status         string // pending / running / completed / failed
+	Status         string // pending / running / completed / failed / skipped
 	Progress       float32
 	CreatedAt      time.Time
 	Options        TaskOptions
 	OptionsSummary TaskOptionsSummary
+	Batch          string // id of the create request, used for batch reports
+	Priority       int    // pending tasks with a higher priority run first
+
+	Title      string
+	Author     string
+	Host       string
+	OutputPath string
+	BytesTotal int64
+	BytesDone  int64
+	Speed      float64 // bytes per second, 0 unless running
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Attempts   int
+	LastError  string
+	ErrorClass string
 }
 
 var (
@@ -43,6 +68,11 @@ var (
 	mu         sync.RWMutex
 	workerOnce sync.Once
 	workerWake = make(chan struct{}, 1)
+	// activeTask is the task the worker is downloading. It may already be
+	// marked failed while waiting for its next attempt. activeCancel stops
+	// it. Both are guarded by mu.
+	activeTask   string
+	activeCancel context.CancelFunc
 )
 
 type DeleteResult int
@@ -65,7 +95,13 @@ func CreateTask(urls []string, reqOpts TaskOptions) ([]*Task, error) {
 		return nil, err
 	}
 
-	vids := downloader.ProcessUrlList(urls)
+	// user pages are listed with the task's own account and cookie
+	session, err := api.NewSession(opts.ProxyURL, opts.Cookie, opts.Account)
+	if err != nil {
+		return nil, err
+	}
+	vids := downloader.ProcessUrlListWithSession(session, urls)
+	batch := newBatchID()
 
 	mu.Lock()
 	var list []*Task
@@ -73,13 +109,17 @@ func CreateTask(urls []string, reqOpts TaskOptions) ([]*Task, error) {
 		if store[vid] != nil {
 			continue
 		}
+		_, host := downloader.VidAndHost(vid)
 		t := &Task{
 			VID:            vid,
+			Host:           host,
 			Status:         "pending",
 			Progress:       0,
 			CreatedAt:      time.Now(),
 			Options:        opts,
 			OptionsSummary: summarizeOptions(opts),
+			Batch:          batch,
+			Priority:       opts.Priority,
 		}
 		store[t.VID] = t
 		list = append(list, cloneTask(t))
@@ -103,27 +143,22 @@ func GetTask(vid string) (*Task, bool) {
 	return cloneTask(t), true
 }
 
-func ListTasks() []*Task {
-	mu.RLock()
-	defer mu.RUnlock()
-	list := make([]*Task, 0, len(store))
-	for _, t := range store {
-		list = append(list, cloneTask(t))
-	}
-	return list
-}
-
 func DeleteTask(vid string) DeleteResult {
 	mu.Lock()
-	defer mu.Unlock()
 	t, ok := store[vid]
 	if !ok {
+		mu.Unlock()
 		return DeleteNotFound
 	}
 	if t.Status != "pending" {
+		mu.Unlock()
 		return DeleteNotPending
 	}
 	delete(store, vid)
+	finished := takeFinishedBatchLocked(t.Batch)
+	mu.Unlock()
+
+	writeBatchReport(finished)
 	return DeleteOK
 }
 
@@ -138,36 +173,76 @@ func workerLoop() {
 	for {
 		<-workerWake
 		for {
-			task := pickPendingTask()
+			task, ctx := pickPendingTask()
 			if task == nil {
 				break
 			}
-			downloadTask(task)
+			downloadTask(ctx, task)
 		}
 	}
 }
 
-func pickPendingTask() *Task {
+// pickPendingTask marks the pending task with the highest priority, oldest
+// first among equals, as running. The context is cancelled when the task is.
+func pickPendingTask() (*Task, context.Context) {
 	mu.Lock()
 	defer mu.Unlock()
-	for _, t := range store {
-		if t.Status != "pending" {
-			continue
+	order := TaskQuery{Sort: "priority"}
+	_ = order.normalize()
+	var t *Task
+	for _, c := range store {
+		if c.Status == "pending" && (t == nil || order.compareTasks(c, t) < 0) {
+			t = c
 		}
+	}
+	if t != nil {
 		t.Status = "running"
 		t.Progress = 0
-		return cloneTask(t)
-	}
-	return nil
+		t.BytesDone = 0
+		t.Speed = 0
+		t.StartedAt = time.Now()
+		t.FinishedAt = time.Time{}
+		activeTask = t.VID
+		ctx, cancel := context.WithCancel(context.Background())
+		activeCancel = cancel
+		return cloneTask(t), ctx
+	}
+	return nil, nil
 }
 
-func downloadTask(task *Task) {
+// cancelActiveLocked stops the download of the active task t. The worker
+// keeps t as cancelled when the download ends. Callers must hold mu.
+func cancelActiveLocked(t *Task) {
+	activeCancel()
+	t.Status = "failed"
+	t.Speed = 0
+	t.LastError = "cancelled"
+	t.ErrorClass = downloader.ErrClassCanceled
+	t.FinishedAt = time.Now()
+}
+
+// runDownload runs one attempt of the download; replaceable in tests.
+var runDownload = downloader.ConcurrentDownloadWithOptions
+
+func downloadTask(ctx context.Context, task *Task) {
 	if task == nil {
 		return
 	}
 
 	downloader.VidList = []string{task.VID}
-	downloader.SetProgressHook(updateTaskProgress)
+	reporter := batchReporter(task.Batch)
+	var lastReport downloader.ProgressReport
+	lastBytes := int64(-1)
+	downloader.SetProgressHook(func(report downloader.ProgressReport) {
+		// reports carry the bare video id while the store is keyed by id@host
+		report.VID = task.VID
+		lastBytes = observeProgress(report, lastBytes)
+		reporter.Observe(report)
+		if report.Done {
+			lastReport = report
+		}
+		updateTaskProgress(report)
+	})
 	defer downloader.SetProgressHook(nil)
 
 	retry := task.Options.MaxRetry
@@ -175,6 +250,8 @@ func downloadTask(task *Task) {
 		retry = 1
 	}
 
+	rate, _ := downloader.ParseRateLimit(task.Options.RateLimit)
+
 	failed := len(downloader.VidList)
 	dlOpts := downloader.DownloadOptions{
 		RootDir:          task.Options.DownloadDir,
@@ -183,31 +260,64 @@ func downloadTask(task *Task) {
 		ProxyURL:         task.Options.ProxyURL,
 		Cookie:           task.Options.Cookie,
 		FilenameTemplate: task.Options.FilenameTemplate,
-	}
-	for i := 0; i < retry && failed > 0; i++ {
-		failed = downloader.ConcurrentDownloadWithOptions(dlOpts)
+		RateLimit:        rate,
+		Account:          task.Options.Account,
+		DirPrefix:        task.Options.DirPrefix,
+	}
+	log := util.Log.With("task", task.VID)
+	for i := 0; i < retry && failed > 0 && ctx.Err() == nil; i++ {
+		log.Info("Running task", "attempt", i+1, "max_retry", retry)
+		failed = runDownload(ctx, dlOpts)
 		if failed > 0 && i < retry-1 {
-			time.Sleep(30 * time.Second)
+			log.Warn("Task failed, retrying in 30s", "attempt", i+1)
+			select {
+			case <-ctx.Done():
+			case <-time.After(30 * time.Second):
+			}
 		}
 	}
 
 	mu.Lock()
-	defer mu.Unlock()
+	// only a cancel through the API has cancelled ctx before this point
+	cancelled := ctx.Err() != nil
+	activeTask = ""
+	activeCancel()
+	activeCancel = nil
 	t, ok := store[task.VID]
 	if !ok {
+		mu.Unlock()
 		return
 	}
-	if t.Status == "running" {
+	if cancelled && !downloader.FindHistory(task.VID) {
+		// cancelled through the API; progress reports may have overwritten the error
+		log.Info("Task cancelled")
+		t.Status = "failed"
+		t.Progress = 0
+		t.LastError = "cancelled"
+		t.ErrorClass = downloader.ErrClassCanceled
+	} else if t.Status == "running" || t.Status == "failed" {
+		// updateTaskProgress marks failed attempts, the last one decides the task
 		if downloader.FindHistory(task.VID) {
 			t.Status = "completed"
 			t.Progress = 1
+			t.FinishedAt = time.Now()
 		} else {
+			log.Error("Task failed", "attempts", retry)
 			t.Status = "failed"
 			t.Progress = 0
+			t.FinishedAt = time.Now()
+			if lastReport.VID == "" {
+				lastReport.VID = task.VID
+			}
+			hook.Fire(configuredHooks(), hook.FromReport(hook.EventFailed, lastReport))
 		}
 	}
 	t.Options.Cookie = ""
 	t.OptionsSummary.CookieSet = false
+	finished := takeFinishedBatchLocked(t.Batch)
+	mu.Unlock()
+
+	writeBatchReport(finished)
 }
 
 func updateTaskProgress(report downloader.ProgressReport) {
@@ -221,20 +331,57 @@ func updateTaskProgress(report downloader.ProgressReport) {
 	if !ok {
 		return
 	}
+	if t.Status == "failed" && t.ErrorClass == downloader.ErrClassCanceled {
+		// cancelled, the transfer is still winding down
+		return
+	}
+
+	if report.Title != "" {
+		t.Title = report.Title
+	}
+	if report.Author != "" {
+		t.Author = report.Author
+	}
+	if report.Host != "" {
+		t.Host = report.Host
+	}
+	if report.FilePath != "" {
+		t.OutputPath = report.FilePath
+	}
+	t.BytesDone = report.BytesComplete
+	if report.BytesTotal > 0 {
+		t.BytesTotal = report.BytesTotal
+	}
 
 	if report.Done {
-		if report.Success {
+		t.Attempts++
+		t.Speed = 0
+		if report.Skipped {
+			t.Status = "skipped"
+			t.Progress = 1
+			t.FinishedAt = time.Now()
+			t.LastError, t.ErrorClass = "", ""
+		} else if report.Success {
 			t.Status = "completed"
 			t.Progress = 1
+			t.FinishedAt = time.Now()
+			t.LastError, t.ErrorClass = "", ""
+			hook.Fire(configuredHooks(), hook.FromReport(hook.EventCompleted, report))
 		} else {
 			t.Status = "failed"
+			if report.Err != nil {
+				t.LastError = report.Err.Error()
+			}
+			t.ErrorClass = downloader.ClassifyError(report.Err)
 		}
 		return
 	}
 
-	if t.Status == "pending" {
+	// a failed attempt is followed by a retry
+	if t.Status == "pending" || t.Status == "failed" {
 		t.Status = "running"
 	}
+	t.Speed = report.Speed
 	if report.BytesTotal > 0 {
 		p := float32(report.BytesComplete) / float32(report.BytesTotal)
 		if p < 0 {
@@ -247,12 +394,65 @@ func updateTaskProgress(report downloader.ProgressReport) {
 	}
 }
 
+// observeProgress feeds a progress report into the download metrics and
+// returns the byte count to diff the next report against, -1 before the
+// first report of an attempt. BytesComplete counts the bytes resumed from a
+// part file too, so an attempt's baseline is the resumed size, or its first
+// report if the server made it start over.
+func observeProgress(report downloader.ProgressReport, lastBytes int64) int64 {
+	if lastBytes < 0 {
+		lastBytes = min(report.BytesResumed, report.BytesComplete)
+	}
+	if delta := report.BytesComplete - lastBytes; delta > 0 {
+		metrics.DownloadedBytes.Add("", float64(delta))
+	}
+	if report.Done {
+		metrics.DownloadSpeed.Set("", 0)
+		if report.Skipped {
+			metrics.DownloadsFinished.Inc("skipped")
+		} else if report.Success {
+			metrics.DownloadsFinished.Inc("success")
+		} else {
+			metrics.DownloadsFinished.Inc("failure")
+		}
+		return -1
+	}
+	metrics.DownloadSpeed.Set("", report.Speed)
+	return report.BytesComplete
+}
+
+// updateTaskMetrics refreshes the task gauges from the store.
+func updateTaskMetrics() {
+	counts := map[string]int{"pending": 0, "running": 0, "completed": 0, "failed": 0, "skipped": 0}
+	mu.RLock()
+	for _, t := range store {
+		counts[t.Status]++
+	}
+	mu.RUnlock()
+	for status, n := range counts {
+		metrics.TasksByStatus.Set(status, float64(n))
+	}
+	metrics.QueueDepth.Set("", float64(counts["pending"]))
+}
+
+// configuredHooks copies the hooks of config.Cfg, which a reload may replace.
+func configuredHooks() []config.Hook {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return slices.Clone(config.Cfg.Hooks)
+}
+
 func resolveTaskOptions(req TaskOptions) (TaskOptions, error) {
+	cfgMu.RLock()
+	cfg := config.Cfg
+	cfgMu.RUnlock()
+
 	opts := TaskOptions{
-		ProxyURL:         strings.TrimSpace(config.Cfg.ProxyUrl),
+		ProxyURL:         strings.TrimSpace(cfg.ProxyUrl),
 		DownloadDir:      "",
-		MaxRetry:         config.Cfg.MaxRetry,
-		FilenameTemplate: strings.TrimSpace(config.Cfg.FilenameTemplate),
+		MaxRetry:         cfg.MaxRetry,
+		FilenameTemplate: strings.TrimSpace(cfg.FilenameTemplate),
+		Account:          api.Default().Account(),
 	}
 	if opts.MaxRetry <= 0 {
 		opts.MaxRetry = 1
@@ -262,14 +462,13 @@ func resolveTaskOptions(req TaskOptions) (TaskOptions, error) {
 	}
 
 	if v := strings.TrimSpace(req.ProxyURL); v != "" {
-		if err := validateProxyURL(v); err != nil {
-			return TaskOptions{}, err
+		if err := api.ValidateProxyURL(v); err != nil {
+			return TaskOptions{}, errors.New("invalid proxy_url: " + err.Error())
 		}
 		opts.ProxyURL = v
 	}
 	if v := strings.TrimSpace(req.DownloadDir); v != "" {
-		converted := downloader.ConvertExternalTemplate(v)
-		if _, err := variableTemplateParser().Parse(converted); err != nil {
+		if err := downloader.ValidateTemplate(v); err != nil {
 			return TaskOptions{}, errors.New("invalid download_dir template")
 		}
 		opts.DownloadDir = v
@@ -281,53 +480,44 @@ func resolveTaskOptions(req TaskOptions) (TaskOptions, error) {
 		opts.Cookie = v
 	}
 	if v := strings.TrimSpace(req.FilenameTemplate); v != "" {
-		converted := downloader.ConvertExternalTemplate(v)
-		if _, err := variableTemplateParser().Parse(converted); err != nil {
+		if err := downloader.ValidateTemplate(v); err != nil {
 			return TaskOptions{}, errors.New("invalid filename_template")
 		}
 		opts.FilenameTemplate = v
 	}
+	if v := strings.TrimSpace(req.RateLimit); v != "" {
+		if _, err := downloader.ParseRateLimit(v); err != nil {
+			return TaskOptions{}, errors.New("invalid rate_limit")
+		}
+		opts.RateLimit = v
+	}
+	if v := strings.TrimSpace(req.Account); v != "" {
+		if _, ok := cfg.LookupAccount(v); !ok {
+			return TaskOptions{}, errors.New("unknown account")
+		}
+		opts.Account = v
+	}
+	opts.Priority = req.Priority
+	opts.DirPrefix = req.DirPrefix
 
 	if opts.DownloadDir == "" {
-		opts.DownloadDir = config.Cfg.RootDir
+		opts.DownloadDir = cfg.RootDir
 	}
 	return opts, nil
 }
 
-func validateProxyURL(raw string) error {
-	u, err := url.Parse(raw)
-	if err != nil {
-		return errors.New("invalid proxy_url")
-	}
-	scheme := strings.ToLower(u.Scheme)
-	if scheme != "http" && scheme != "https" && scheme != "socks5" {
-		return errors.New("proxy_url scheme must be http, https or socks5")
-	}
-	return nil
-}
-
 func summarizeOptions(opts TaskOptions) TaskOptionsSummary {
 	return TaskOptionsSummary{
-		ProxyURL:         opts.ProxyURL,
+		ProxyURL:         config.MaskURL(opts.ProxyURL), // read tokens see it too
 		DownloadDir:      opts.DownloadDir,
 		CookieSet:        opts.Cookie != "",
 		MaxRetry:         opts.MaxRetry,
 		FilenameTemplate: opts.FilenameTemplate,
+		RateLimit:        opts.RateLimit,
+		Account:          opts.Account,
 	}
 }
 
-func variableTemplateParser() *template.Template {
-	return template.New("filename").Funcs(template.FuncMap{
-		"now":             func(layout ...string) string { return "" },
-		"publish_time":    func(layout ...string) string { return "" },
-		"title":           func() string { return "" },
-		"video_id":        func() string { return "" },
-		"author":          func() string { return "" },
-		"author_nickname": func() string { return "" },
-		"quality":         func() string { return "" },
-	})
-}
-
 func cloneTask(t *Task) *Task {
 	if t == nil {
 		return nil
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"iwaradl/config"
+	"iwaradl/downloader"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of API tokens. admin includes the other two.
+const (
+	ScopeRead    = "read"    // view tasks, the rate limit and metrics
+	ScopeEnqueue = "enqueue" // create tasks
+	ScopeAdmin   = "admin"   // everything, including cancelling tasks and changing settings
+)
+
+var allScopes = []string{ScopeRead, ScopeEnqueue, ScopeAdmin}
+
+// legacyTokenName names apiToken from the config, which has every scope.
+const legacyTokenName = "apiToken"
+
+// apiToken is the token a request authenticated with.
+type apiToken struct {
+	Name string
+	config.ApiToken
+}
+
+func (t *apiToken) has(scope string) bool {
+	return slices.Contains(t.Scopes, ScopeAdmin) || slices.Contains(t.Scopes, scope)
+}
+
+type tokenContextKey struct{}
+
+func tokenFrom(ctx context.Context) *apiToken {
+	t, _ := ctx.Value(tokenContextKey{}).(*apiToken)
+	return t
+}
+
+// TokenResp describes a configured token for GET /api/tokens. The token
+// itself is never returned.
+type TokenResp struct {
+	Name              string     `json:"name"`
+	Scopes            []string   `json:"scopes"`
+	DownloadDirPrefix string     `json:"download_dir_prefix,omitempty"`
+	NoCustomProxy     bool       `json:"no_custom_proxy"`
+	RateLimit         string     `json:"rate_limit,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+	Requests          int64      `json:"requests"` // authenticated requests since the daemon started
+}
+
+type tokenUsage struct {
+	lastUsed time.Time
+	requests int64
+}
+
+var (
+	usageMu sync.Mutex
+	usage   = make(map[string]tokenUsage)
+)
+
+func recordTokenUse(name string) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	u := usage[name]
+	u.lastUsed = time.Now()
+	u.requests++
+	usage[name] = u
+}
+
+// lookupToken finds the configured token equal to provided. Every token is
+// compared, so the time taken does not tell which one matched.
+func lookupToken(provided string) (*apiToken, bool) {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	var found *apiToken
+	if tokenEqual(config.Cfg.ApiToken, provided) {
+		found = &apiToken{Name: legacyTokenName, ApiToken: config.ApiToken{Scopes: []string{ScopeAdmin}}}
+	}
+	for name, t := range config.Cfg.ApiTokens {
+		if tokenEqual(t.Token, provided) && found == nil {
+			found = &apiToken{Name: name, ApiToken: t}
+		}
+	}
+	return found, found != nil
+}
+
+func tokenEqual(token, provided string) bool {
+	token = strings.TrimSpace(token)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(provided)) == 1
+}
+
+// requireScope rejects requests whose token lacks scope. It runs after
+// authMiddleware.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t := tokenFrom(r.Context()); t == nil || !t.has(scope) {
+				respondJSON(w, http.StatusForbidden, map[string]string{"error": "token lacks the " + scope + " scope"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// restrict applies the token's restrictions to the options of new tasks.
+func (t *apiToken) restrict(opts TaskOptions, rootDir string) (TaskOptions, error) {
+	if t.NoCustomProxy && strings.TrimSpace(opts.ProxyURL) != "" {
+		return opts, errors.New("this token may not set proxy_url")
+	}
+	if prefix := strings.TrimSpace(t.DownloadDirPrefix); prefix != "" {
+		if strings.TrimSpace(opts.DownloadDir) == "" {
+			opts.DownloadDir = prefix
+		}
+		if !withinDir(opts.DownloadDir, prefix, rootDir) {
+			return opts, fmt.Errorf("download_dir must be inside %s for this token", prefix)
+		}
+		// checked again against the resolved path when the task runs
+		opts.DirPrefix = absDir(prefix, rootDir)
+	}
+	if limit := strings.TrimSpace(t.RateLimit); limit != "" {
+		most, _ := downloader.ParseRateLimit(limit) // checked by ValidateTokens
+		// an invalid rate_limit is left for resolveTaskOptions to report
+		if rate, err := downloader.ParseRateLimit(opts.RateLimit); err == nil && (rate == 0 || rate > most) {
+			opts.RateLimit = limit
+		}
+	}
+	return opts, nil
+}
+
+// withinDir reports whether every directory the download_dir template tpl
+// can resolve to lies inside prefix. Only the part before the first template
+// variable is known up front, so that part must be inside prefix, and ".."
+// is refused anywhere.
+func withinDir(tpl, prefix, rootDir string) bool {
+	tpl = downloader.ConvertExternalTemplate(tpl)
+	static, _, templated := strings.Cut(tpl, "{{")
+	if templated {
+		static = static[:strings.LastIndexAny(static, `/\`)+1]
+	}
+	for _, part := range strings.FieldsFunc(tpl, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if strings.TrimSpace(part) == ".." {
+			return false
+		}
+	}
+	return downloader.WithinDir(absDir(static, rootDir), absDir(prefix, rootDir))
+}
+
+// absDir resolves p against rootDir the way download_dir templates are.
+func absDir(p, rootDir string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(rootDir, p)
+	}
+	return filepath.Clean(p)
+}
+
+// ValidateTokens checks the apiTokens section of cfg.
+func ValidateTokens(cfg config.Config) error {
+	var errs []error
+	seen := make(map[string]string)
+	if t := strings.TrimSpace(cfg.ApiToken); t != "" {
+		seen[t] = "apiToken"
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.ApiTokens)) {
+		t := cfg.ApiTokens[name]
+		key := "apiTokens." + name
+		switch token := strings.TrimSpace(t.Token); {
+		case token == "":
+			errs = append(errs, errors.New(key+": token is empty"))
+		case seen[token] != "":
+			errs = append(errs, errors.New(key+": same token as "+seen[token]))
+		default:
+			seen[token] = key
+		}
+		if len(t.Scopes) == 0 {
+			errs = append(errs, errors.New(key+": no scopes, allowed values: read, enqueue, admin"))
+		}
+		for _, s := range t.Scopes {
+			if !slices.Contains(allScopes, s) {
+				errs = append(errs, fmt.Errorf("%s: unknown scope %q, allowed values: read, enqueue, admin", key, s))
+			}
+		}
+		if t.RateLimit != "" {
+			if rate, err := downloader.ParseRateLimit(t.RateLimit); err != nil || rate == 0 {
+				errs = append(errs, errors.New(key+": invalid rateLimit"))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listTokens returns the configured tokens with their usage, by name.
+func listTokens() []TokenResp {
+	cfgMu.RLock()
+	tokens := []apiToken{}
+	if config.Cfg.ApiToken != "" {
+		tokens = append(tokens, apiToken{Name: legacyTokenName, ApiToken: config.ApiToken{Scopes: []string{ScopeAdmin}}})
+	}
+	for name, t := range config.Cfg.ApiTokens {
+		tokens = append(tokens, apiToken{Name: name, ApiToken: t})
+	}
+	cfgMu.RUnlock()
+	slices.SortFunc(tokens, func(a, b apiToken) int { return strings.Compare(a.Name, b.Name) })
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	list := make([]TokenResp, len(tokens))
+	for i, t := range tokens {
+		u := usage[t.Name]
+		list[i] = TokenResp{
+			Name:              t.Name,
+			Scopes:            append([]string{}, t.Scopes...),
+			DownloadDirPrefix: t.DownloadDirPrefix,
+			NoCustomProxy:     t.NoCustomProxy,
+			RateLimit:         t.RateLimit,
+			Requests:          u.requests,
+		}
+		if !u.lastUsed.IsZero() {
+			list[i].LastUsedAt = &u.lastUsed
+		}
+	}
+	return list
+}
+
+// GET /api/tokens
+func getTokens(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, listTokens())
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"iwaradl/config"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenScopes(t *testing.T) {
+	config.Cfg = config.Defaults()
+	config.Cfg.RootDir = t.TempDir()
+	config.Cfg.ApiToken = "root"
+	config.Cfg.ApiTokens = map[string]config.ApiToken{
+		"viewer": {Token: "view-secret", Scopes: []string{ScopeRead}},
+		"bot": {Token: "bot-secret", Scopes: []string{ScopeEnqueue}, DownloadDirPrefix: "inbox",
+			NoCustomProxy: true, RateLimit: "1M"},
+	}
+	setStore(t)
+	ts := httptest.NewServer(NewRouter())
+	defer ts.Close()
+
+	do := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	tests := []struct {
+		token, method, path, body string
+		want                      int
+	}{
+		{"wrong", "GET", "/api/tasks", "", http.StatusUnauthorized},
+		{"view-secret", "GET", "/api/tasks", "", http.StatusOK},
+		{"view-secret", "GET", "/metrics", "", http.StatusOK},
+		{"view-secret", "POST", "/api/tasks", `{"urls":["https://www.iwara.tv/video/a1"]}`, http.StatusForbidden},
+		{"view-secret", "GET", "/api/tokens", "", http.StatusForbidden},
+		{"bot-secret", "GET", "/api/tasks", "", http.StatusForbidden},
+		{"bot-secret", "POST", "/api/tasks", `{"urls":["https://www.iwara.tv/video/a1"],"options":{"proxy_url":"http://p:1"}}`, http.StatusForbidden},
+		{"bot-secret", "POST", "/api/tasks", `{"urls":["https://www.iwara.tv/video/a1"],"options":{"download_dir":"/tmp"}}`, http.StatusForbidden},
+		{"bot-secret", "POST", "/api/tasks", `{"urls":["https://www.iwara.tv/video/a1"],"options":{"download_dir":"inbox/../x"}}`, http.StatusForbidden},
+		{"bot-secret", "POST", "/api/tasks", `{"urls":["https://www.iwara.tv/video/a1"],"options":{"rate_limit":"5M"}}`, http.StatusCreated},
+		{"bot-secret", "POST", "/api/tasks", `{"urls":["https://www.iwara.tv/video/a2"],"options":{"download_dir":"inbox/{{author}}"}}`, http.StatusCreated},
+		{"root", "GET", "/api/tokens", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, body := do(tt.token, tt.method, tt.path, tt.body); code != tt.want {
+			t.Errorf("%s %s %s with %s = %d %s, want %d", tt.method, tt.path, tt.body, tt.token, code, body, tt.want)
+		}
+	}
+
+	task, ok := GetTask("a1@www.iwara.tv")
+	if !ok {
+		t.Fatal("task a1 not created")
+	}
+	if want := filepath.Join(config.Cfg.RootDir, "inbox"); task.Options.DownloadDir != "inbox" && task.Options.DownloadDir != want {
+		t.Errorf("download dir = %q, want the token's prefix", task.Options.DownloadDir)
+	}
+	if want := filepath.Join(config.Cfg.RootDir, "inbox"); task.Options.DirPrefix != want {
+		t.Errorf("dir prefix = %q, want %q", task.Options.DirPrefix, want)
+	}
+	if task.Options.RateLimit != "1M" {
+		t.Errorf("rate limit = %q, want the token's 1M", task.Options.RateLimit)
+	}
+
+	_, body := do("root", "GET", "/api/tokens", "")
+	var tokens []TokenResp
+	if err := json.Unmarshal([]byte(body), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 || tokens[0].Name != "apiToken" || tokens[1].Name != "bot" || tokens[1].Requests != 6 || tokens[1].LastUsedAt == nil {
+		t.Errorf("tokens = %s", body)
+	}
+	if strings.Contains(body, "secret") {
+		t.Errorf("token values leaked: %s", body)
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	root := filepath.FromSlash("/data")
+	tests := []struct {
+		tpl, prefix string
+		want        bool
+	}{
+		{"inbox", "inbox", true},
+		{"inbox/{{author}}", "inbox", true},
+		{"/data/inbox/x", "inbox", true},
+		{"inbox{{author}}", "inbox", false},
+		{"{{author}}", "inbox", false},
+		{"inbox/../etc", "inbox", false},
+		{"inbox/{{author}}/..", "inbox", false},
+		{"/etc", "inbox", false},
+		{"inbox2", "inbox", false},
+		{"%#AUTHOR#%", ".", true},
+	}
+	for _, tt := range tests {
+		if got := withinDir(tt.tpl, tt.prefix, root); got != tt.want {
+			t.Errorf("withinDir(%q, %q) = %v, want %v", tt.tpl, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTokens(t *testing.T) {
+	cfg := config.Config{
+		ApiToken: "same",
+		ApiTokens: map[string]config.ApiToken{
+			"a": {Token: "same", Scopes: []string{ScopeRead}},
+			"b": {Token: "b", Scopes: []string{"write"}},
+			"c": {Token: "", RateLimit: "fast"},
+		},
+	}
+	err := ValidateTokens(cfg)
+	if err == nil {
+		t.Fatal("invalid tokens accepted")
+	}
+	for _, want := range []string{"apiTokens.a: same token as apiToken", `apiTokens.b: unknown scope "write"`,
+		"apiTokens.c: token is empty", "apiTokens.c: no scopes", "apiTokens.c: invalid rateLimit"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+	cfg.ApiTokens = map[string]config.ApiToken{"a": {Token: "a", Scopes: []string{ScopeAdmin}, RateLimit: "2M"}}
+	if err := ValidateTokens(cfg); err != nil {
+		t.Errorf("valid tokens rejected: %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+// Dashboard of the iwaradl daemon. It only uses the HTTP API documented at
+// /api/openapi.json, authenticated with the daemon's API token.
+"use strict";
+
+const TOKEN_KEY = "iwaradl.token";
+const POLL_MS = 2000;
+const HISTORY_PAGE = 50;
+
+let token = localStorage.getItem(TOKEN_KEY) || sessionStorage.getItem(TOKEN_KEY) || "";
+let view = "queue";
+let pollTimer = null;
+let historyCursor = "";
+
+const $ = (id) => document.getElementById(id);
+
+class Unauthorized extends Error {}
+
+async function api(method, path, body) {
+  const headers = { Authorization: "Bearer " + token };
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    throw new Unauthorized("unauthorized");
+  }
+  if (!resp.ok) {
+    let text = (await resp.text()).trim();
+    // auth failures, such as a token lacking a scope, come as JSON
+    try {
+      text = JSON.parse(text).error || text;
+    } catch (e) {
+      // plain-text error
+    }
+    throw new Error(text || resp.status + " " + resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function showMessage(text, isError) {
+  const el = $("message");
+  el.textContent = text;
+  el.className = isError ? "error" : "";
+  el.hidden = !text;
+}
+
+function handleError(err) {
+  if (err instanceof Unauthorized) {
+    signOut("The API token was rejected.");
+    return;
+  }
+  showMessage(err.message, true);
+}
+
+// el builds an element; text is set with textContent so task titles and
+// errors from the daemon are never parsed as HTML.
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === "text") {
+      node.textContent = v;
+    } else if (k === "onclick") {
+      node.addEventListener("click", v);
+    } else {
+      node.setAttribute(k, v);
+    }
+  }
+  node.append(...children.filter((c) => c !== null && c !== undefined));
+  return node;
+}
+
+function formatBytes(n) {
+  if (!n) {
+    return "";
+  }
+  const units = ["B", "kB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1000 && i < units.length - 1) {
+    n /= 1000;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function formatTime(s) {
+  return s ? new Date(s).toLocaleString() : "";
+}
+
+function videoCell(t) {
+  const [id, host] = t.vid.split("@");
+  const link = el("a", { href: "https://" + (host || "www.iwara.tv") + "/video/" + id, target: "_blank", rel: "noreferrer", text: t.title || id });
+  return el("td", { class: "video" }, link, el("small", { text: [t.author, t.vid].filter(Boolean).join(" · ") }));
+}
+
+function progressCell(t) {
+  const pct = Math.round((t.progress || 0) * 100);
+  const fill = el("div", { class: "fill " + t.status });
+  fill.style.width = pct + "%"; // through CSSOM, the CSP forbids style attributes
+  const bar = el("div", { class: "bar" }, fill);
+  const parts = [pct + "%"];
+  if (t.bytes_total) {
+    parts.push(formatBytes(t.bytes_done) + " / " + formatBytes(t.bytes_total));
+  }
+  if (t.status === "running" && t.speed > 0) {
+    parts.push(formatBytes(t.speed) + "/s");
+  }
+  return el("td", { class: "wide" }, bar, el("small", { text: parts.join(" · ") }));
+}
+
+function actionButton(label, action, t) {
+  return el("button", { type: "button", text: label, onclick: () => bulk({ action, vids: [t.vid] }) });
+}
+
+function actionsCell(t) {
+  const td = el("td", { class: "actions" });
+  if (t.status === "pending" || t.status === "running") {
+    td.append(actionButton("Cancel", "cancel", t));
+  }
+  if (t.status === "failed") {
+    td.append(actionButton("Retry", "retry", t));
+  }
+  if (t.status !== "running" && t.status !== "pending") {
+    td.append(actionButton("Remove", "delete", t));
+  }
+  return td;
+}
+
+function statusCell(t) {
+  return el("td", {}, el("span", { class: "status " + t.status, text: t.status }));
+}
+
+async function bulk(req, confirmText) {
+  if (confirmText && !confirm(confirmText)) {
+    return;
+  }
+  try {
+    const resp = await api("POST", "api/tasks/bulk", req);
+    const failed = resp.results.filter((r) => !r.ok);
+    if (failed.length > 0) {
+      const lines = failed.map((r) => r.vid + ": " + r.error);
+      showMessage(resp.succeeded + " task(s) updated, " + failed.length + " not:\n" + lines.join("\n"), true);
+    } else {
+      showMessage(resp.succeeded + " task(s) updated (" + req.action + ").", false);
+    }
+    refresh();
+  } catch (err) {
+    handleError(err);
+  }
+}
+
+// listAll fetches every task selected by params, following next_cursor.
+async function listAll(params) {
+  const tasks = [];
+  for (;;) {
+    const list = await api("GET", "api/tasks?" + params);
+    tasks.push(...list.tasks);
+    if (!list.next_cursor) {
+      return tasks;
+    }
+    params.set("cursor", list.next_cursor);
+  }
+}
+
+async function loadQueue() {
+  const tasks = await listAll(new URLSearchParams({ status: "running,pending", sort: "priority" }));
+  // running first, then pending in the order the worker will pick them
+  tasks.sort((a, b) => (b.status === "running") - (a.status === "running"));
+  const rows = tasks.map((t) =>
+    el("tr", {}, videoCell(t), statusCell(t), progressCell(t), el("td", { text: String(t.priority) }),
+      el("td", { text: String(t.attempts) }), actionsCell(t)));
+  $("queue-rows").replaceChildren(...rows);
+  $("queue-empty").hidden = rows.length > 0;
+  const running = tasks.filter((t) => t.status === "running").length;
+  $("queue-summary").textContent = running + " running, " + (tasks.length - running) + " pending";
+}
+
+function historyQuery() {
+  const form = $("history-filter");
+  const params = new URLSearchParams({
+    status: form.status.value,
+    sort: "created_at",
+    order: "desc",
+    limit: String(HISTORY_PAGE),
+  });
+  const q = form.q.value.trim();
+  if (q) {
+    params.set("q", q);
+  }
+  return params;
+}
+
+function historyRow(t) {
+  const error = t.error_class ? t.error_class + ": " + (t.last_error || "") : "";
+  return el("tr", {}, videoCell(t), statusCell(t), el("td", { text: formatTime(t.finished_at) }),
+    el("td", { text: formatBytes(t.bytes_total) }), el("td", { class: "error-text", text: error, title: t.output_path || "" }),
+    actionsCell(t));
+}
+
+async function loadHistory(more) {
+  const params = historyQuery();
+  if (more && historyCursor) {
+    params.set("cursor", historyCursor);
+  }
+  const list = await api("GET", "api/tasks?" + params);
+  const rows = list.tasks.map(historyRow);
+  if (more) {
+    $("history-rows").append(...rows);
+  } else {
+    $("history-rows").replaceChildren(...rows);
+  }
+  historyCursor = list.next_cursor || "";
+  $("history-more").hidden = !historyCursor;
+  $("history-empty").hidden = $("history-rows").children.length > 0;
+}
+
+async function refresh() {
+  if (!token) {
+    return;
+  }
+  try {
+    if (view === "queue") {
+      await loadQueue();
+    } else if (view === "history") {
+      await loadHistory(false);
+    }
+  } catch (err) {
+    handleError(err);
+  }
+}
+
+function show(name) {
+  view = name;
+  for (const section of document.querySelectorAll(".view")) {
+    section.hidden = section.id !== name;
+  }
+  for (const b of document.querySelectorAll("nav button")) {
+    b.classList.toggle("active", b.dataset.view === name);
+  }
+  clearInterval(pollTimer);
+  pollTimer = null;
+  if (name === "queue") {
+    pollTimer = setInterval(refresh, POLL_MS);
+  }
+  refresh();
+}
+
+function signOut(reason) {
+  token = "";
+  localStorage.removeItem(TOKEN_KEY);
+  sessionStorage.removeItem(TOKEN_KEY);
+  clearInterval(pollTimer);
+  pollTimer = null;
+  for (const section of document.querySelectorAll(".view")) {
+    section.hidden = true;
+  }
+  document.querySelector("nav").hidden = true;
+  $("logout").hidden = true;
+  $("login").hidden = false;
+  showMessage(reason || "", Boolean(reason));
+}
+
+function signIn(newToken, remember) {
+  token = newToken;
+  (remember ? localStorage : sessionStorage).setItem(TOKEN_KEY, token);
+  $("login").hidden = true;
+  document.querySelector("nav").hidden = false;
+  $("logout").hidden = false;
+  showMessage("", false);
+  show(view);
+}
+
+async function addTasks(form) {
+  const urls = form.urls.value.split("\n").map((s) => s.trim()).filter(Boolean);
+  const options = {};
+  for (const name of ["download_dir", "filename_template", "account", "proxy_url", "rate_limit", "cookie"]) {
+    const v = form[name].value.trim();
+    if (v) {
+      options[name] = v;
+    }
+  }
+  for (const name of ["max_retry", "priority"]) {
+    const v = parseInt(form[name].value, 10);
+    if (v) {
+      options[name] = v;
+    }
+  }
+  try {
+    const tasks = await api("POST", "api/tasks", { urls, options });
+    form.urls.value = "";
+    form.cookie.value = "";
+    showMessage(tasks.length + " task(s) added.", false);
+    show("queue");
+  } catch (err) {
+    handleError(err);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  for (const b of document.querySelectorAll("nav button")) {
+    b.addEventListener("click", () => show(b.dataset.view));
+  }
+  $("logout").addEventListener("click", () => signOut(""));
+  $("login-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const form = e.target;
+    signIn(form.token.value.trim(), form.remember.checked);
+    form.token.value = "";
+  });
+  $("add-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    addTasks(e.target);
+  });
+  $("history-filter").addEventListener("submit", (e) => {
+    e.preventDefault();
+    refresh();
+  });
+  $("history-more").addEventListener("click", () => loadHistory(true).catch(handleError));
+  $("retry-failed").addEventListener("click", () =>
+    bulk({ action: "retry", filter: { status: ["failed"] } }, "Retry all failed tasks?"));
+  $("purge").addEventListener("click", () =>
+    bulk({ action: "delete", filter: { status: ["completed", "failed", "skipped"] } }, "Remove all finished tasks from the list? Downloaded files are kept."));
+
+  if (token) {
+    signIn(token, Boolean(localStorage.getItem(TOKEN_KEY)));
+  } else {
+    signOut("");
+  }
+});
@@ -1,41 +1,86 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 )
 
 var Debug bool
 
-// DebugLog 输出调试日志，包含时间戳和代码位置
-func DebugLog(format string, v ...any) {
-	if !Debug {
-		return
-	}
+// Log is the process-wide leveled logger. It writes text to stderr until
+// InitLogger is called.
+var Log = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
-	// 获取时间戳
-	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+// LogOptions configures InitLogger.
+type LogOptions struct {
+	Level      string // debug / info / warn / error
+	Format     string // text / json
+	File       string // empty means stderr
+	MaxSizeMB  int    // rotate the log file after this size, 0 disables rotation
+	MaxBackups int    // rotated files to keep
+}
 
-	// 获取代码位置
-	_, file, line, ok := runtime.Caller(1)
-	if !ok {
-		file = "unknown"
-		line = 0
+// InitLogger replaces Log according to opts. Debug forces the debug level.
+func InitLogger(opts LogOptions) error {
+	level, err := parseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		level = slog.LevelDebug
 	}
 
-	// 获取文件名（不含路径）
-	shortFile := file
-	for i := len(file) - 1; i > 0; i-- {
-		if file[i] == '/' {
-			shortFile = file[i+1:]
-			break
+	var w io.Writer = os.Stderr
+	if opts.File != "" {
+		rw, err := newRotatingWriter(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups)
+		if err != nil {
+			return err
 		}
+		w = rw
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level, AddSource: level == slog.LevelDebug}
+	switch strings.ToLower(strings.TrimSpace(opts.Format)) {
+	case "", "text":
+		Log = slog.New(slog.NewTextHandler(w, handlerOpts))
+	case "json":
+		Log = slog.New(slog.NewJSONHandler(w, handlerOpts))
+	default:
+		return errors.New("invalid log format: " + opts.Format + ", allowed values: text, json")
 	}
+	return nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, errors.New("invalid log level: " + s + ", allowed values: debug, info, warn, error")
+}
 
-	// 格式化消息
-	message := fmt.Sprintf(format, v...)
+// DebugLog 输出调试日志，包含代码位置
+func DebugLog(format string, v ...any) {
+	if !Log.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
 
-	// 输出日志
-	fmt.Printf("[%s] [%s:%d] %s\n", timestamp, shortFile, line, message)
+	// 记录调用者位置，而不是本函数
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.LevelDebug, fmt.Sprintf(format, v...), pcs[0])
+	_ = Log.Handler().Handle(context.Background(), r)
 }
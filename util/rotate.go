@@ -0,0 +1,95 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// rotatingWriter appends to a log file and rotates it to file.1, file.2, ...
+// once it grows past maxSize bytes.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func newRotatingWriter(path string, maxSize int64, maxBackups int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.f = f
+	w.size = fi.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var rotateErr error
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		rotateErr = w.rotate()
+	} else if w.f == nil {
+		rotateErr = w.open()
+	}
+	if w.f == nil {
+		return 0, errors.Join(errors.New("log file is closed"), rotateErr)
+	}
+	// a failed rotation keeps writing to the current file
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate moves the log file to the first backup and opens a new one. The
+// file at path is open again afterwards unless that fails too, so a rename
+// error does not stop logging.
+func (w *rotatingWriter) rotate() error {
+	_ = w.f.Close()
+	w.f = nil
+
+	var err error
+	if w.maxBackups <= 0 {
+		_ = os.Remove(w.path)
+	} else {
+		_ = os.Remove(w.backupName(w.maxBackups))
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(w.backupName(i), w.backupName(i+1))
+		}
+		err = os.Rename(w.path, w.backupName(1))
+	}
+	if openErr := w.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+func (w *rotatingWriter) backupName(i int) string {
+	return w.path + "." + strconv.Itoa(i)
+}
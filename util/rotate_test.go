@@ -0,0 +1,62 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iwaradl.log")
+	w, err := newRotatingWriter(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = w.f.Close() }()
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{path: "third\n", path + ".1": "second\n", path + ".2": "first\n"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+}
+
+func TestRotatingWriterRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iwaradl.log")
+	// a non-empty directory in place of the backup makes the rename fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newRotatingWriter(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = w.f.Close() }()
+
+	if _, err := w.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Write([]byte("second\n")); err == nil || n != len("second\n") {
+		t.Errorf("Write during a failed rotation = %d, %v, want the line written and the error", n, err)
+	}
+	if _, err := w.Write([]byte("third\n")); err == nil {
+		t.Error("rotation error not reported")
+	}
+
+	// once the rename works again the writer rotates
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("fourth\n")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{path: "fourth\n", path + ".1": "first\nsecond\nthird\n"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+}
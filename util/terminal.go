@@ -11,6 +11,11 @@ import (
 	"golang.org/x/term"
 )
 
+// IsTerminal reports whether stdout is attached to a terminal
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
 // GetTerminalWidth returns the terminal width, or 120 if detection fails
 func GetTerminalWidth() int {
 	// Try to get terminal width